@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Identity is the signed claim carried by a player token.
+type Identity struct {
+	PlayerID  string `json:"id"`
+	Name      string `json:"name"`
+	Guest     bool   `json:"guest"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var errInvalidToken = errors.New("invalid token")
+var errExpiredToken = errors.New("token expired")
+
+var tokenSecret []byte
+
+// InitAuth loads the HMAC secret used to sign player tokens. Without
+// TOKEN_SECRET a random one is generated, so tokens do not survive a restart.
+func InitAuth() {
+	secret := os.Getenv("TOKEN_SECRET")
+	if secret != "" {
+		tokenSecret = []byte(secret)
+		return
+	}
+
+	log.Println("Warning: TOKEN_SECRET not set, using a random secret for player tokens")
+	tokenSecret = make([]byte, 32)
+	rand.Read(tokenSecret)
+}
+
+func newPlayerId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func signToken(identity Identity) (string, error) {
+	payload, err := json.Marshal(identity)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + tokenSignature(encoded), nil
+}
+
+func tokenSignature(encodedPayload string) string {
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(encodedPayload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifyToken(token string) (Identity, error) {
+	var identity Identity
+
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(tokenSignature(encoded))) {
+		return identity, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return identity, errInvalidToken
+	}
+	if err := json.Unmarshal(payload, &identity); err != nil || identity.PlayerID == "" {
+		return identity, errInvalidToken
+	}
+	if time.Now().Unix() > identity.ExpiresAt {
+		return identity, errExpiredToken
+	}
+	return identity, nil
+}
+
+// identityFromRequest reads the player token from the "token" query parameter,
+// falling back to an Authorization bearer header for non-browser clients.
+func identityFromRequest(req *http.Request) (Identity, error) {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		token = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		return Identity{}, errInvalidToken
+	}
+	return verifyToken(token)
+}
+
+type TokenResponse struct {
+	Token     string `json:"token"`
+	PlayerID  string `json:"playerId"`
+	Name      string `json:"name"`
+	Guest     bool   `json:"guest"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// tokenHandler issues a signed token for a new player. Posting a "name" gives a
+// named identity, otherwise a guest one is created.
+func tokenHandler(w http.ResponseWriter, r *http.Request) {
+	// The game client is served from a different origin
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	} else {
+		body.Name = r.FormValue("name")
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if len(name) > 32 {
+		http.Error(w, "Name is too long", http.StatusBadRequest)
+		return
+	}
+
+	identity := Identity{
+		PlayerID:  newPlayerId(),
+		Name:      name,
+		Guest:     name == "",
+		ExpiresAt: time.Now().Add(Settings.TokenTTL).Unix(),
+	}
+	if identity.Guest {
+		identity.Name = "Guest-" + identity.PlayerID[:4]
+	}
+
+	token, err := signToken(identity)
+	if err != nil {
+		log.Println("Error signing token:", err)
+		http.Error(w, "Could not issue token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TokenResponse{
+		Token:     token,
+		PlayerID:  identity.PlayerID,
+		Name:      identity.Name,
+		Guest:     identity.Guest,
+		ExpiresAt: identity.ExpiresAt,
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Maximum number of frames waiting to be written to a single client.
+const sendQueueSize = 64
+
+// Largest frame a client may send. Events are a few hundred bytes, a client
+// that sends more is disconnected.
+const maxMessageSize = 8 << 10
+
+// Frames for these events are superseded by the next one, so they can be
+// dropped when a client falls behind.
+var droppableEvents = map[string]bool{
+	"snake_update": true,
+	"snake_delta":  true,
+}
+
+type outboundFrame struct {
+	event     string
+	frameType int
+	data      []byte
+}
+
+type Client struct {
+	conn        *websocket.Conn
+	isConnected bool
+	playerId    string   // Authenticated player ID, taken from the token.
+	identity    Identity // The verified token claims.
+	roomId      string   // The room that the player belongs to.
+	spectating  bool     // Spectators receive room broadcasts but cannot play.
+	codec       *Codec   // Wire format negotiated during the upgrade.
+
+	deltaUpdates  bool        // Client opted in to snake_delta messages.
+	needsKeyframe atomic.Bool // Next game update must be a full snake_update.
+
+	rateLimits map[string]*tokenBucket // Per event, see RateLimit.
+
+	queueMutex sync.Mutex
+	queue      []outboundFrame
+	notify     chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newClient(conn *websocket.Conn, identity Identity) *Client {
+	client := &Client{
+		conn:        conn,
+		isConnected: true,
+		playerId:    identity.PlayerID,
+		identity:    identity,
+		codec:       codecFor(conn.Subprotocol()),
+		queue:       make([]outboundFrame, 0, sendQueueSize),
+		notify:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	client.needsKeyframe.Store(true)
+	return client
+}
+
+// send encodes the message with the client's codec and queues it for the
+// writer goroutine.
+func (c *Client) send(message BroadcastMessage) {
+	msgBytes, err := c.codec.Marshal(message)
+	if err != nil {
+		log.Println("Error encoding message:", err)
+		return
+	}
+	c.enqueue(c.codec.FrameType, message.GetEvent(), msgBytes)
+}
+
+// sendEncoded is send for broadcasts: the encoded message is cached per codec
+// so it is only marshalled once for all recipients.
+func (c *Client) sendEncoded(message BroadcastMessage, encoded map[*Codec][]byte) {
+	msgBytes, ok := encoded[c.codec]
+	if !ok {
+		var err error
+		msgBytes, err = c.codec.Marshal(message)
+		if err != nil {
+			log.Println("Error encoding message:", err)
+			return
+		}
+		encoded[c.codec] = msgBytes
+	}
+	c.enqueue(c.codec.FrameType, message.GetEvent(), msgBytes)
+}
+
+// enqueue adds an encoded frame to the outbound queue. When the queue is full
+// stale droppable frames are discarded first; if that does not free any space
+// the client is too slow to keep up and gets disconnected.
+func (c *Client) enqueue(frameType int, event string, data []byte) {
+	c.queueMutex.Lock()
+	if len(c.queue) >= sendQueueSize {
+		kept := c.queue[:0]
+		for _, frame := range c.queue {
+			if !droppableEvents[frame.event] {
+				kept = append(kept, frame)
+			}
+		}
+		if len(kept) < len(c.queue) {
+			// Deltas only apply on top of the frames before them
+			c.needsKeyframe.Store(true)
+		}
+		c.queue = kept
+	}
+	if len(c.queue) >= sendQueueSize {
+		c.queueMutex.Unlock()
+		log.Printf("Send queue full for player %s, disconnecting", c.playerId)
+		c.close()
+		return
+	}
+	c.queue = append(c.queue, outboundFrame{event: event, frameType: frameType, data: data})
+	c.queueMutex.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// writePump is the only goroutine allowed to write to the connection. It also
+// sends the heartbeat pings.
+func (c *Client) writePump() {
+	pingTicker := time.NewTicker(Settings.PingInterval)
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-pingTicker.C:
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(Settings.WriteTimeout))
+			if err != nil {
+				log.Printf("Error sending ping to player %s: %v", c.playerId, err)
+				c.close()
+				return
+			}
+			continue
+		case <-c.notify:
+		}
+
+		c.queueMutex.Lock()
+		frames := c.queue
+		c.queue = make([]outboundFrame, 0, sendQueueSize)
+		c.queueMutex.Unlock()
+
+		for _, frame := range frames {
+			c.conn.SetWriteDeadline(time.Now().Add(Settings.WriteTimeout))
+			err := c.conn.WriteMessage(frame.frameType, frame.data)
+			if err != nil {
+				log.Printf("Error sending %s to player %s: %v", frame.event, c.playerId, err)
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// closeWith closes the connection with a close frame giving the reason. Frames
+// still queued are dropped.
+func (c *Client) closeWith(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(Settings.WriteTimeout))
+	c.close()
+}
+
+// close stops the writer and closes the connection, which makes the reader
+// fail and run the normal disconnection path.
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"go/ws/game"
+	"math/rand"
+)
+
+// Bot steers one snake. It returns the direction to turn to before the next
+// tick, or false to keep going.
+type Bot func(board *game.Board, snakes map[string]game.Snake, id string, rng *rand.Rand) (game.Vector, bool)
+
+var bots = map[string]Bot{
+	"straight": straightBot,
+	"random":   randomBot,
+	"greedy":   greedyBot,
+}
+
+var directions = []game.Vector{{X: -1, Y: 0}, {X: 1, Y: 0}, {X: 0, Y: -1}, {X: 0, Y: 1}}
+
+// straightBot never turns.
+func straightBot(board *game.Board, snakes map[string]game.Snake, id string, rng *rand.Rand) (game.Vector, bool) {
+	return game.Vector{}, false
+}
+
+// randomBot turns a random way now and then, without looking.
+func randomBot(board *game.Board, snakes map[string]game.Snake, id string, rng *rand.Rand) (game.Vector, bool) {
+	if rng.Intn(5) != 0 {
+		return game.Vector{}, false
+	}
+	return directions[rng.Intn(len(directions))], true
+}
+
+// greedyBot heads for the nearest food along moves that do not kill it on
+// the next tick.
+func greedyBot(board *game.Board, snakes map[string]game.Snake, id string, rng *rand.Rand) (game.Vector, bool) {
+	snake := snakes[id]
+	blocked := occupied(snakes)
+
+	best, bestDistance := snake.Speed, -1
+	for _, direction := range directions {
+		if direction.X == -snake.Speed.X && direction.Y == -snake.Speed.Y {
+			continue
+		}
+		next, onBoard := step(board, game.Vector{X: snake.X, Y: snake.Y}, direction)
+		if !onBoard || blocked[next] {
+			continue
+		}
+
+		distance := nearestFood(board, next)
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = direction, distance
+		}
+	}
+	return best, best != snake.Speed
+}
+
+// occupied returns the cells a head must not move into.
+func occupied(snakes map[string]game.Snake) map[game.Vector]bool {
+	cells := make(map[game.Vector]bool)
+	for _, snake := range snakes {
+		if snake.IsDead {
+			continue
+		}
+		cells[game.Vector{X: snake.X, Y: snake.Y}] = true
+		for _, segment := range snake.Tail {
+			cells[segment] = true
+		}
+	}
+	return cells
+}
+
+// step is where a head moving in direction ends up, wrapping around the way
+// Snake.Update does. It reports false for a wall.
+func step(board *game.Board, from game.Vector, direction game.Vector) (game.Vector, bool) {
+	next := game.Vector{X: from.X + direction.X, Y: from.Y + direction.Y}
+	outside := next.X < 0 || next.X >= board.Size || next.Y < 0 || next.Y >= board.Size
+	if outside && board.Walls {
+		return next, false
+	}
+	next.X = wrap(next.X, board.Size)
+	next.Y = wrap(next.Y, board.Size)
+	return next, true
+}
+
+func wrap(n, size int) int {
+	if n >= size {
+		return 0
+	} else if n < 0 {
+		return size
+	}
+	return n
+}
+
+func nearestFood(board *game.Board, from game.Vector) int {
+	nearest := -1
+	for _, food := range board.Food {
+		dx := abs(food[0].(int) - from.X)
+		dy := abs(food[1].(int) - from.Y)
+		if !board.Walls {
+			dx = min(dx, board.Size-dx)
+			dy = min(dy, board.Size-dy)
+		}
+		if nearest < 0 || dx+dy < nearest {
+			nearest = dx + dy
+		}
+	}
+	return nearest
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,218 @@
+// Command simulate plays bot matches with the server's game rules, without a
+// server or any connections, and prints statistics for tuning food scores
+// and board sizes.
+//
+//	go run ./cmd/simulate -matches 500 -players 4 -bots greedy,random -size 30
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ws/game"
+	"log"
+	"maps"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type options struct {
+	matches  int
+	players  int
+	bots     []string
+	size     int
+	food     int
+	walls    bool
+	maxTicks uint64
+	seed     int64
+	fps      int
+	json     bool
+}
+
+// playerResult is how one snake did in a match.
+type playerResult struct {
+	ID     string
+	Bot    string
+	Score  int
+	Length int
+	Cause  game.DeathCause // Empty if the snake was alive at the tick limit
+	DiedAt uint64
+	Eaten  map[string]int
+	Winner bool
+}
+
+type matchResult struct {
+	Seed     int64
+	Ticks    uint64
+	TimedOut bool
+	Players  []playerResult
+}
+
+func main() {
+	var opts options
+	var botList, scores string
+	flag.IntVar(&opts.matches, "matches", 100, "Matches to play")
+	flag.IntVar(&opts.players, "players", 2, "Snakes in each match")
+	flag.StringVar(&botList, "bots", "greedy", "Comma separated bots, handed to the players in turn: "+strings.Join(slices.Sorted(maps.Keys(bots)), ", "))
+	flag.IntVar(&opts.size, "size", 20, "Cells along each side of the board")
+	flag.IntVar(&opts.food, "food", 11, "Pieces of food on the board")
+	flag.BoolVar(&opts.walls, "walls", false, "Snakes die at the edge instead of wrapping around")
+	flag.Uint64Var(&opts.maxTicks, "max-ticks", 5000, "Ticks after which a match is stopped")
+	flag.Int64Var(&opts.seed, "seed", 0, "Seed of the first match, the others count up from it (default random)")
+	flag.IntVar(&opts.fps, "fps", 10, "Ticks per second, only used to show game lengths in seconds")
+	flag.StringVar(&scores, "score", "", "Food scores to use instead of the server's, such as chili=300,banana=200")
+	flag.BoolVar(&opts.json, "json", false, "Print the statistics as JSON")
+	flag.Parse()
+
+	opts.bots = strings.Split(botList, ",")
+	for _, name := range opts.bots {
+		if bots[name] == nil {
+			log.Fatalf("Unknown bot %q", name)
+		}
+	}
+	if opts.matches < 1 || opts.players < 1 || opts.size < 1 || opts.food < 0 || opts.fps < 1 {
+		log.Fatal("-matches, -players, -size and -fps must be positive")
+	}
+	if err := setFoodScores(scores); err != nil {
+		log.Fatal(err)
+	}
+	if opts.seed == 0 {
+		opts.seed = time.Now().UnixNano()
+	}
+
+	results := make([]matchResult, opts.matches)
+	for i := range results {
+		results[i] = runMatch(opts, opts.seed+int64(i))
+	}
+
+	summary := summarize(opts, results)
+	if opts.json {
+		summary.writeJSON(os.Stdout)
+	} else {
+		summary.writeText(os.Stdout)
+	}
+}
+
+// setFoodScores overrides game.FoodScore from a list such as chili=300,banana=200.
+func setFoodScores(value string) error {
+	if value == "" {
+		return nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		food, score, found := strings.Cut(pair, "=")
+		points, err := strconv.Atoi(score)
+		if !found || err != nil || !slices.Contains(game.FoodTypes, food) {
+			return fmt.Errorf("invalid food score %q", pair)
+		}
+		game.FoodScore[food] = points
+	}
+	return nil
+}
+
+// runMatch plays one match until every snake is dead or the tick limit is
+// reached. Everything random in it comes from seed, so a match can be played
+// again.
+func runMatch(opts options, seed int64) matchResult {
+	rng := rand.New(rand.NewSource(seed))
+	board := &game.Board{
+		Size:  opts.size,
+		Walls: opts.walls,
+		Food:  game.GenerateFood(rng, opts.size, opts.food),
+		Rng:   rng,
+	}
+	botRng := rand.New(rand.NewSource(rng.Int63()))
+
+	snakes := make(map[string]game.Snake, opts.players)
+	players := make(map[string]*playerResult, opts.players)
+	ids := make([]string, opts.players)
+	taken := make(map[game.Vector]bool)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("p%d", i+1)
+
+		// Every snake starts on its own cell, when there are enough of them
+		start := game.Vector{X: botRng.Intn(opts.size), Y: botRng.Intn(opts.size)}
+		for taken[start] && len(taken) < opts.size*opts.size {
+			start = game.Vector{X: botRng.Intn(opts.size), Y: botRng.Intn(opts.size)}
+		}
+		taken[start] = true
+
+		snakes[ids[i]] = game.Snake{
+			X:     start.X,
+			Y:     start.Y,
+			Speed: directions[botRng.Intn(len(directions))],
+			Tail:  []game.Vector{},
+		}
+		players[ids[i]] = &playerResult{
+			ID:    ids[i],
+			Bot:   opts.bots[i%len(opts.bots)],
+			Eaten: make(map[string]int),
+		}
+	}
+
+	match := matchResult{Seed: seed}
+	alive := slices.Clone(ids)
+	for len(alive) > 0 {
+		if match.Ticks == opts.maxTicks {
+			match.TimedOut = true
+			break
+		}
+		match.Ticks++
+
+		for _, id := range alive {
+			snake := snakes[id]
+			if direction, turn := bots[players[id].Bot](board, snakes, id, botRng); turn {
+				snake.Turn(direction)
+			}
+			snakes[id] = snake
+		}
+
+		result := board.Step(snakes, alive)
+		for id, food := range result.Eaten {
+			players[id].Eaten[food]++
+		}
+		for _, id := range result.Died {
+			players[id].Cause = snakes[id].Cause
+			players[id].DiedAt = match.Ticks
+		}
+		alive = slices.DeleteFunc(alive, func(id string) bool {
+			return snakes[id].IsDead
+		})
+	}
+
+	for _, id := range ids {
+		player := players[id]
+		player.Score = snakes[id].Score
+		player.Length = snakes[id].Size + 1
+		if player.DiedAt == 0 {
+			player.DiedAt = match.Ticks
+		}
+		match.Players = append(match.Players, *player)
+	}
+	markWinner(match.Players)
+	return match
+}
+
+// markWinner picks the snake that survived longest, then the one with the
+// best score, as the server's results do. A tie has no winner.
+func markWinner(players []playerResult) {
+	better := func(a, b playerResult) bool {
+		return a.DiedAt > b.DiedAt || (a.DiedAt == b.DiedAt && a.Score > b.Score)
+	}
+
+	best := 0
+	tied := false
+	for i := 1; i < len(players); i++ {
+		switch {
+		case better(players[i], players[best]):
+			best, tied = i, false
+		case !better(players[best], players[i]):
+			tied = true
+		}
+	}
+	if !tied && len(players) > 1 {
+		players[best].Winner = true
+	}
+}
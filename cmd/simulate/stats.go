@@ -0,0 +1,231 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"go/ws/game"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Summary is what the matches add up to.
+type Summary struct {
+	Matches    int            `json:"matches"`
+	FirstSeed  int64          `json:"firstSeed"`
+	Players    int            `json:"players"`
+	Size       int            `json:"size"`
+	Food       int            `json:"food"`
+	Walls      bool           `json:"walls"`
+	Length     LengthStats    `json:"length"`
+	Scores     Distribution   `json:"scores"`
+	FoodTypes  []FoodStats    `json:"foodTypes"`
+	Deaths     []DeathStats   `json:"deaths"`
+	Bots       []BotStats     `json:"bots"`
+	FoodScores map[string]int `json:"foodScores"`
+	fps        int
+}
+
+// LengthStats is how long the matches lasted, in ticks.
+type LengthStats struct {
+	Average  float64 `json:"average"`
+	Min      uint64  `json:"min"`
+	Max      uint64  `json:"max"`
+	TimedOut int     `json:"timedOut"` // Matches stopped at the tick limit
+}
+
+// Distribution summarizes a set of numbers.
+type Distribution struct {
+	Mean   float64 `json:"mean"`
+	Median int     `json:"median"`
+	P90    int     `json:"p90"`
+	Max    int     `json:"max"`
+}
+
+// FoodStats is how much of one food type was eaten and what it was worth.
+type FoodStats struct {
+	Type    string  `json:"type"`
+	Eaten   int     `json:"eaten"`
+	PerGame float64 `json:"perGame"`
+	Points  int     `json:"points"`
+	Share   float64 `json:"share"` // Of all points scored
+}
+
+// DeathStats counts how snakes died. Snakes alive at the tick limit count as
+// "alive".
+type DeathStats struct {
+	Cause string  `json:"cause"`
+	Count int     `json:"count"`
+	Share float64 `json:"share"`
+}
+
+// BotStats is how well one bot did.
+type BotStats struct {
+	Bot          string  `json:"bot"`
+	Snakes       int     `json:"snakes"`
+	Wins         int     `json:"wins"`
+	AverageScore float64 `json:"averageScore"`
+	Survival     float64 `json:"survival"` // Average ticks survived
+}
+
+func summarize(opts options, results []matchResult) Summary {
+	summary := Summary{
+		Matches:    len(results),
+		FirstSeed:  opts.seed,
+		Players:    opts.players,
+		Size:       opts.size,
+		Food:       opts.food,
+		Walls:      opts.walls,
+		FoodScores: game.FoodScore,
+		fps:        opts.fps,
+	}
+
+	var scores []int
+	var totalTicks uint64
+	eaten := make(map[string]int)
+	deaths := make(map[string]int)
+	botStats := make(map[string]*BotStats)
+	summary.Length.Min = results[0].Ticks
+	for _, match := range results {
+		totalTicks += match.Ticks
+		summary.Length.Min = min(summary.Length.Min, match.Ticks)
+		summary.Length.Max = max(summary.Length.Max, match.Ticks)
+		if match.TimedOut {
+			summary.Length.TimedOut++
+		}
+
+		for _, player := range match.Players {
+			scores = append(scores, player.Score)
+			for food, count := range player.Eaten {
+				eaten[food] += count
+			}
+
+			cause := string(player.Cause)
+			if cause == "" {
+				cause = "alive"
+			}
+			deaths[cause]++
+
+			stats, exists := botStats[player.Bot]
+			if !exists {
+				stats = &BotStats{Bot: player.Bot}
+				botStats[player.Bot] = stats
+			}
+			stats.Snakes++
+			stats.AverageScore += float64(player.Score)
+			stats.Survival += float64(player.DiedAt)
+			if player.Winner {
+				stats.Wins++
+			}
+		}
+	}
+	summary.Length.Average = float64(totalTicks) / float64(len(results))
+	summary.Scores = distribution(scores)
+
+	totalPoints := 0
+	for _, food := range game.FoodTypes {
+		totalPoints += eaten[food] * game.FoodScore[food]
+	}
+	for _, food := range game.FoodTypes {
+		stats := FoodStats{
+			Type:    food,
+			Eaten:   eaten[food],
+			PerGame: float64(eaten[food]) / float64(len(results)),
+			Points:  eaten[food] * game.FoodScore[food],
+		}
+		if totalPoints > 0 {
+			stats.Share = float64(stats.Points) / float64(totalPoints)
+		}
+		summary.FoodTypes = append(summary.FoodTypes, stats)
+	}
+	slices.SortStableFunc(summary.FoodTypes, func(a, b FoodStats) int {
+		return cmp.Compare(b.Points, a.Points)
+	})
+
+	for _, cause := range slices.Sorted(maps.Keys(deaths)) {
+		summary.Deaths = append(summary.Deaths, DeathStats{
+			Cause: cause,
+			Count: deaths[cause],
+			Share: float64(deaths[cause]) / float64(len(scores)),
+		})
+	}
+
+	for _, bot := range slices.Sorted(maps.Keys(botStats)) {
+		stats := botStats[bot]
+		stats.AverageScore /= float64(stats.Snakes)
+		stats.Survival /= float64(stats.Snakes)
+		summary.Bots = append(summary.Bots, *stats)
+	}
+	return summary
+}
+
+func distribution(values []int) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	sorted := slices.Sorted(slices.Values(values))
+	total := 0
+	for _, value := range sorted {
+		total += value
+	}
+	return Distribution{
+		Mean:   float64(total) / float64(len(sorted)),
+		Median: sorted[len(sorted)/2],
+		P90:    sorted[len(sorted)*9/10],
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+func (s Summary) writeJSON(w io.Writer) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(s)
+}
+
+func (s Summary) writeText(w io.Writer) {
+	walls := "wrapping"
+	if s.Walls {
+		walls = "walls"
+	}
+	fmt.Fprintf(w, "%d matches from seed %d, %d players, %dx%d board with %s, %d food\n\n",
+		s.Matches, s.FirstSeed, s.Players, s.Size, s.Size, walls, s.Food)
+
+	fmt.Fprintf(w, "Game length: %.1f ticks on average (%.1fs at %d FPS), shortest %d, longest %d",
+		s.Length.Average, s.Length.Average/float64(s.fps), s.fps, s.Length.Min, s.Length.Max)
+	if s.Length.TimedOut > 0 {
+		fmt.Fprintf(w, ", %d stopped at the tick limit", s.Length.TimedOut)
+	}
+	fmt.Fprintf(w, "\nScores: mean %.1f, median %d, p90 %d, best %d\n\n",
+		s.Scores.Mean, s.Scores.Median, s.Scores.P90, s.Scores.Max)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "Food\tScore\tEaten\tPer game\tPoints\tShare\t")
+	for _, food := range s.FoodTypes {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%.2f\t%d\t%s\t\n",
+			food.Type, s.FoodScores[food.Type], food.Eaten, food.PerGame, food.Points, percent(food.Share))
+	}
+	table.Flush()
+	fmt.Fprintln(w)
+
+	table = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "Death\tCount\tShare\t")
+	for _, death := range s.Deaths {
+		fmt.Fprintf(table, "%s\t%d\t%s\t\n", death.Cause, death.Count, percent(death.Share))
+	}
+	table.Flush()
+	fmt.Fprintln(w)
+
+	table = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "Bot\tSnakes\tWins\tAvg score\tAvg ticks alive\t")
+	for _, bot := range s.Bots {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%.1f\t%.1f\t\n", bot.Bot, bot.Snakes, bot.Wins, bot.AverageScore, bot.Survival)
+	}
+	table.Flush()
+}
+
+func percent(share float64) string {
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", share*100), ".0") + "%"
+}
@@ -1,9 +1,5 @@
 package main
 
-import (
-	"math/rand"
-)
-
 type Config struct {
 	BackgroundNumber int    `json:"backgroundNumber"`
 	Side             int    `json:"side"`
@@ -19,23 +15,18 @@ type Config struct {
 	} `json:"waitingRoom"`
 }
 
-func GenerateFoodCoordinates(foodCount int) [][]any {
-	coordinates := make([][]any, foodCount)
-
-	for i := range foodCount {
-		x := rand.Intn(GameConfigJSON.ScaleFactor)
-		y := rand.Intn(GameConfigJSON.ScaleFactor)
-
-		typeIndex := rand.Intn(len(foodTypes))
-		coordinates[i] = []any{x, y, i, foodTypes[typeIndex]}
-	}
-
-	return coordinates
-}
-
 var directionMap = map[string]struct{ X, Y int }{
 	"l": {X: -1, Y: 0},
 	"r": {X: 1, Y: 0},
 	"u": {X: 0, Y: -1},
 	"d": {X: 0, Y: 1},
 }
+
+// directions holds the values of directionMap in a fixed order, since ranging
+// over the map would make random picks differ between runs with the same seed.
+var directions = []struct{ X, Y int }{
+	directionMap["l"],
+	directionMap["r"],
+	directionMap["u"],
+	directionMap["d"],
+}
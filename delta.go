@@ -0,0 +1,111 @@
+package main
+
+import (
+	"slices"
+	"sort"
+)
+
+// SnakeDelta describes how one snake changed since the previous tick. Only
+// the fields that changed are set.
+type SnakeDelta struct {
+	ID      string   `json:"id"`
+	Head    *Vector  `json:"head,omitempty"`    // New head position
+	Trim    int      `json:"trim,omitempty"`    // Segments dropped from the start of the tail
+	Append  []Vector `json:"append,omitempty"`  // Segments added to the end of the tail
+	Score   *int     `json:"score,omitempty"`   // New score
+	IsDead  bool     `json:"isDead,omitempty"`  // Set on the tick the snake died
+	Colours *Colours `json:"colours,omitempty"` // New colours
+	Speed   *Vector  `json:"speed,omitempty"`   // New direction
+	Player  *Player  `json:"player,omitempty"`  // Full state for snakes the client has not seen
+	Removed bool     `json:"removed,omitempty"` // The snake left the game
+
+	Disconnected *bool `json:"disconnected,omitempty"`
+}
+
+// copySnakes takes a deep copy of the snakes map. Snake.Update shifts tails
+// in place, so sharing the tail slices would corrupt the previous state.
+func copySnakes(snakesMap map[string]Player) map[string]Player {
+	snapshot := make(map[string]Player, len(snakesMap))
+	for id, player := range snakesMap {
+		player.Snake.Tail = slices.Clone(player.Snake.Tail)
+		snapshot[id] = player
+	}
+	return snapshot
+}
+
+// diffSnakes lists the changes that turn previous into current, sorted by
+// snake ID. Snakes that did not change are left out.
+func diffSnakes(previous, current map[string]Player) []SnakeDelta {
+	deltas := []SnakeDelta{}
+
+	for id, player := range current {
+		before, existed := previous[id]
+		if !existed {
+			deltas = append(deltas, SnakeDelta{ID: id, Player: &player})
+			continue
+		}
+
+		delta := SnakeDelta{ID: id}
+		changed := false
+
+		if before.Snake.X != player.Snake.X || before.Snake.Y != player.Snake.Y {
+			delta.Head = &Vector{X: player.Snake.X, Y: player.Snake.Y}
+			changed = true
+		}
+		if trim, grown := diffTail(before.Snake.Tail, player.Snake.Tail); trim > 0 || len(grown) > 0 {
+			delta.Trim = trim
+			delta.Append = grown
+			changed = true
+		}
+		if before.Snake.Score != player.Snake.Score {
+			score := player.Snake.Score
+			delta.Score = &score
+			changed = true
+		}
+		if !before.Snake.IsDead && player.Snake.IsDead {
+			delta.IsDead = true
+			changed = true
+		}
+		if before.Colours != player.Colours {
+			colours := player.Colours
+			delta.Colours = &colours
+			changed = true
+		}
+		if before.Snake.Speed != player.Snake.Speed {
+			speed := player.Snake.Speed
+			delta.Speed = &speed
+			changed = true
+		}
+		if before.Disconnected != player.Disconnected {
+			disconnected := player.Disconnected
+			delta.Disconnected = &disconnected
+			changed = true
+		}
+
+		if changed {
+			deltas = append(deltas, delta)
+		}
+	}
+
+	for id := range previous {
+		if _, exists := current[id]; !exists {
+			deltas = append(deltas, SnakeDelta{ID: id, Removed: true})
+		}
+	}
+
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].ID < deltas[j].ID })
+	return deltas
+}
+
+// diffTail finds the smallest number of segments to drop from the start of
+// before so that what is left is a prefix of after, and returns that count
+// with the segments after it that are new.
+func diffTail(before, after []Vector) (int, []Vector) {
+	for trim := 0; trim <= len(before); trim++ {
+		kept := before[trim:]
+		if len(kept) <= len(after) && slices.Equal(kept, after[:len(kept)]) {
+			return trim, slices.Clone(after[len(kept):])
+		}
+	}
+	return len(before), slices.Clone(after)
+}
@@ -0,0 +1,39 @@
+package main
+
+import "log"
+
+// ErrorCode identifies a failure in an error event. Codes are part of the
+// client protocol and must not be renamed.
+type ErrorCode string
+
+const (
+	ErrBadMessage       ErrorCode = "bad_message"         // The message could not be parsed
+	ErrUnknownEvent     ErrorCode = "unknown_event"       // No handler for the event
+	ErrRoomNotFound     ErrorCode = "room_not_found"      // The room does not exist (any more)
+	ErrGameStarted      ErrorCode = "game_started"        // Only allowed before the game starts
+	ErrNotInWaitingRoom ErrorCode = "not_in_waiting_room" // The player has not joined the waiting room
+	ErrRoomEmpty        ErrorCode = "room_empty"          // Nobody is in the waiting room
+	ErrNotEnoughPlayers ErrorCode = "not_enough_players"  // Fewer players than the game mode needs
+	ErrWrongPassword    ErrorCode = "wrong_password"      // The private room's password does not match
+	ErrRoomFull         ErrorCode = "room_full"           // The room has no space or its game has started
+	ErrNotHost          ErrorCode = "not_host"            // Only the room's host may do this
+	ErrPlayerNotFound   ErrorCode = "player_not_found"    // The target player is not in the room
+	ErrGameNotFinished  ErrorCode = "game_not_finished"   // Only allowed after the game is over
+	ErrInvalidDirection ErrorCode = "invalid_direction"   // Unknown movement key
+	ErrNotAllowed       ErrorCode = "not_allowed"         // The client may not send this event
+	ErrRateLimited      ErrorCode = "rate_limited"        // Too many events of this kind
+	ErrInternal         ErrorCode = "internal_error"      // The server failed to handle the event
+)
+
+// sendError reports a failed request back to the client. requestEvent and
+// requestId identify the message that caused it and may be empty.
+func (c *Client) sendError(code ErrorCode, message string, requestEvent string, requestId string) {
+	log.Printf("Error for player %s on %q: %s", c.playerId, requestEvent, message)
+	c.send(ErrorMessage{
+		Event:        "error",
+		Code:         code,
+		Message:      message,
+		RequestEvent: requestEvent,
+		RequestID:    requestId,
+	})
+}
@@ -1,15 +1,31 @@
 package main
 
+// Message is the envelope shared by every client event. The rest of the
+// payload is decoded by the event's handler.
 type Message struct {
-	Event  string  `json:"event"`
-	Player Player  `json:"player,omitempty"`
-	Key    string  `json:"key,omitempty"`
-	ID     string  `json:"id,omitempty"`
-	Config *Config `json:"config,omitempty"`
+	Event     string `json:"event"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Frame ties a message sent by a room to the simulation step it belongs to.
+// ServerTime is in Unix milliseconds.
+type Frame struct {
+	Tick       uint64 `json:"tick"`
+	ServerTime int64  `json:"serverTime"`
+}
+
+func (f *Frame) setFrame(frame Frame) {
+	*f = frame
+}
+
+// framedMessage is implemented by pointers to messages that embed Frame.
+type framedMessage interface {
+	setFrame(frame Frame)
 }
 
 type EventMessage struct {
 	Event string `json:"event"`
+	Frame
 }
 
 func (m EventMessage) GetEvent() string {
@@ -17,7 +33,8 @@ func (m EventMessage) GetEvent() string {
 }
 
 type SnakeUpdateMessage struct {
-	Event     string            `json:"event"`
+	Event string `json:"event"`
+	Frame
 	SnakesMap map[string]Player `json:"snakesMap"`
 }
 
@@ -26,9 +43,11 @@ func (m SnakeUpdateMessage) GetEvent() string {
 }
 
 type ConfigMessage struct {
-	Event  string  `json:"event"`
-	Config *Config `json:"config,omitempty"`
-	Food   [][]any `json:"food"`
+	Event string `json:"event"`
+	Frame
+	Config *Config   `json:"config,omitempty"`
+	Food   [][]any   `json:"food"`
+	Mode   *GameMode `json:"mode,omitempty"` // The room's rules, such as walls
 }
 
 func (m ConfigMessage) GetEvent() string {
@@ -36,10 +55,203 @@ func (m ConfigMessage) GetEvent() string {
 }
 
 type FoodUpdateMessage struct {
-	Event string  `json:"event"`
-	Food  [][]any `json:"food"`
+	Event string `json:"event"`
+	Frame
+	Food [][]any `json:"food"`
 }
 
 func (m FoodUpdateMessage) GetEvent() string {
 	return m.Event
 }
+
+type WaitingRoomStatusMessage struct {
+	Event string `json:"event"`
+	Frame
+	Players []Player `json:"players"`
+	HostID  string   `json:"hostId"`
+}
+
+func (m WaitingRoomStatusMessage) GetEvent() string {
+	return m.Event
+}
+
+type SessionMessage struct {
+	Event       string `json:"event"`
+	PlayerID    string `json:"playerId"`
+	RoomID      string `json:"roomId"`
+	ResumeToken string `json:"resumeToken"`
+	Resumed     bool   `json:"resumed,omitempty"`
+	Mode        string `json:"mode"`
+	Private     bool   `json:"private,omitempty"`
+	JoinCode    string `json:"joinCode,omitempty"` // Share it to invite players to a private room
+}
+
+func (m SessionMessage) GetEvent() string {
+	return m.Event
+}
+
+// SnakeDeltaMessage carries the changes since BaseTick. Clients that are not
+// on BaseTick must ask for a resync.
+type SnakeDeltaMessage struct {
+	Event string `json:"event"`
+	Frame
+	BaseTick uint64       `json:"baseTick"`
+	Snakes   []SnakeDelta `json:"snakes"`
+}
+
+func (m SnakeDeltaMessage) GetEvent() string {
+	return m.Event
+}
+
+// PongMessage answers a "ping" event. ClientTime is echoed back so the client
+// can measure round trip time and estimate its clock offset from ServerTime.
+type PongMessage struct {
+	Event string `json:"event"`
+	Frame
+	ClientTime int64 `json:"clientTime"`
+}
+
+func (m PongMessage) GetEvent() string {
+	return m.Event
+}
+
+type ErrorMessage struct {
+	Event        string    `json:"event"`
+	Code         ErrorCode `json:"code"`
+	Message      string    `json:"message"`
+	RequestEvent string    `json:"requestEvent,omitempty"`
+	RequestID    string    `json:"requestId,omitempty"`
+}
+
+func (m ErrorMessage) GetEvent() string {
+	return m.Event
+}
+
+// RoomStateMessage is broadcast whenever a room moves through its lifecycle.
+type RoomStateMessage struct {
+	Event string `json:"event"`
+	Frame
+	RoomID   string    `json:"roomId"`
+	State    RoomState `json:"state"`
+	Previous RoomState `json:"previous"`
+}
+
+func (m RoomStateMessage) GetEvent() string {
+	return m.Event
+}
+
+// QueuedMessage tells a player they are waiting for enough players to start a
+// room in their game mode.
+type QueuedMessage struct {
+	Event   string `json:"event"`
+	Mode    string `json:"mode"`
+	Waiting int    `json:"waiting"` // Players in the queue, including this one
+	Needed  int    `json:"needed"`
+}
+
+func (m QueuedMessage) GetEvent() string {
+	return m.Event
+}
+
+// HostChangedMessage announces the room's new host. HostID is empty while no
+// player is connected.
+type HostChangedMessage struct {
+	Event string `json:"event"`
+	Frame
+	HostID string `json:"hostId"`
+}
+
+func (m HostChangedMessage) GetEvent() string {
+	return m.Event
+}
+
+// CountdownMessage is sent once a second before the first tick.
+type CountdownMessage struct {
+	Event string `json:"event"`
+	Frame
+	Seconds int `json:"seconds"`
+}
+
+func (m CountdownMessage) GetEvent() string {
+	return m.Event
+}
+
+// RoomListMessage answers listRooms with every public room.
+type RoomListMessage struct {
+	Event string        `json:"event"`
+	Rooms []RoomSummary `json:"rooms"`
+}
+
+func (m RoomListMessage) GetEvent() string {
+	return m.Event
+}
+
+// RoomUpdateMessage tells lobby subscribers a public room was created or
+// changed.
+type RoomUpdateMessage struct {
+	Event string      `json:"event"`
+	Room  RoomSummary `json:"room"`
+}
+
+func (m RoomUpdateMessage) GetEvent() string {
+	return m.Event
+}
+
+type RoomRemovedMessage struct {
+	Event string `json:"event"`
+	ID    string `json:"id"`
+}
+
+func (m RoomRemovedMessage) GetEvent() string {
+	return m.Event
+}
+
+// RematchStatusMessage reports the rematch vote after a game. The room goes
+// back to the waiting room when len(Votes) reaches Needed.
+type RematchStatusMessage struct {
+	Event string `json:"event"`
+	Frame
+	Votes  []string `json:"votes"` // IDs of the players who voted
+	Needed int      `json:"needed"`
+}
+
+func (m RematchStatusMessage) GetEvent() string {
+	return m.Event
+}
+
+// GameStartMessage starts a game. Seed is the room RNG's seed for it, which
+// with the players' inputs is enough to play the game again.
+type GameStartMessage struct {
+	Event string `json:"event"`
+	Frame
+	Seed int64 `json:"seed"`
+}
+
+func (m GameStartMessage) GetEvent() string {
+	return m.Event
+}
+
+// GameOverMessage ends a game with its results.
+type GameOverMessage struct {
+	Event string `json:"event"`
+	Frame
+	Results GameResults `json:"results"`
+}
+
+func (m GameOverMessage) GetEvent() string {
+	return m.Event
+}
+
+// SnakeDiedMessage is broadcast on the tick a snake dies. Killer is the other
+// snake for collision and head_on deaths.
+type SnakeDiedMessage struct {
+	Event string `json:"event"`
+	Frame
+	ID     string     `json:"id"`
+	Cause  DeathCause `json:"cause"`
+	Killer string     `json:"killer,omitempty"`
+}
+
+func (m SnakeDiedMessage) GetEvent() string {
+	return m.Event
+}
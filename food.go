@@ -1,13 +0,0 @@
-package main
-
-var foodTypes = []string{"redApple", "greenApple", "yellowApple", "banana", "cherry", "chili", "strawberry"}
-
-var foodScore = map[string]int{
-	"redApple":    50,
-	"greenApple":  10,
-	"yellowApple": 100,
-	"chili":       700,
-	"strawberry":  1000,
-	"cherry":      30,
-	"banana":      500,
-}
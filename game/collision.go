@@ -0,0 +1,90 @@
+package game
+
+import (
+	"maps"
+	"slices"
+)
+
+type collisionDeath struct {
+	cause  DeathCause
+	killer string
+}
+
+// resolveCollisions decides which snakes die once every snake has moved, as
+// if they all moved at the same time. moved holds the head position before
+// the move of every snake that moved this tick; the other live snakes stood
+// still and are obstacles. Snakes that were already dead are not. A snake
+// that dies this tick, including at a wall, is still an obstacle this tick.
+//
+// For each moving snake the first rule that applies decides:
+//   - head_on: its head ends on the cell another moving head ends on, or the
+//     two swap cells and pass through each other. Both die and name each
+//     other as killer.
+//   - self: its head ends on its own tail.
+//   - collision: its head ends on another snake's tail, or on the head of a
+//     snake that did not move. That snake gets the kill.
+//
+// When several snakes match a rule, the one with the lowest ID is the killer.
+func resolveCollisions(snakes map[string]Snake, moved map[string]Vector) map[string]collisionDeath {
+	deaths := make(map[string]collisionDeath)
+	ids := slices.Sorted(maps.Keys(snakes))
+
+	for _, id := range ids {
+		previous, moving := moved[id]
+		snake := snakes[id]
+		if !moving || snake.IsDead || !collides(snake) {
+			continue
+		}
+		head := Vector{X: snake.X, Y: snake.Y}
+
+		if killer, found := headOn(id, head, previous, snakes, moved, ids); found {
+			deaths[id] = collisionDeath{cause: DeathHeadOn, killer: killer}
+			continue
+		}
+
+		if slices.Contains(snake.Tail, head) {
+			deaths[id] = collisionDeath{cause: DeathSelf}
+			continue
+		}
+
+		for _, otherId := range ids {
+			other := snakes[otherId]
+			_, otherMoving := moved[otherId]
+			if otherId == id || (other.IsDead && !otherMoving) || !collides(other) {
+				continue
+			}
+
+			stoodStill := !otherMoving || other.IsDead
+			if slices.Contains(other.Tail, head) || (stoodStill && other.X == head.X && other.Y == head.Y) {
+				deaths[id] = collisionDeath{cause: DeathCollision, killer: otherId}
+				break
+			}
+		}
+	}
+
+	return deaths
+}
+
+// headOn finds another moving snake whose head meets this one's, on the same
+// cell or by swapping cells.
+func headOn(id string, head Vector, previous Vector, snakes map[string]Snake, moved map[string]Vector, ids []string) (string, bool) {
+	for _, otherId := range ids {
+		otherPrevious, otherMoving := moved[otherId]
+		other := snakes[otherId]
+		if otherId == id || !otherMoving || other.IsDead || !collides(other) {
+			continue
+		}
+
+		otherHead := Vector{X: other.X, Y: other.Y}
+		if otherHead == head || (otherHead == previous && otherPrevious == head) {
+			return otherId, true
+		}
+	}
+	return "", false
+}
+
+// collides reports whether the snake takes part in collisions at all. The
+// server snake only does when ServerSnakeCollision is set.
+func collides(snake Snake) bool {
+	return snake.Type != "server" || ServerSnakeCollision
+}
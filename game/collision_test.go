@@ -0,0 +1,186 @@
+package game
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestCollisions(t *testing.T) {
+	right := Vector{X: 1, Y: 0}
+	left := Vector{X: -1, Y: 0}
+	up := Vector{X: 0, Y: -1}
+	down := Vector{X: 0, Y: 1}
+
+	type death struct {
+		cause  DeathCause
+		killer string
+	}
+
+	tests := []struct {
+		name            string
+		walls           bool
+		serverCollision bool
+		snakes          map[string]Snake
+		moving          []string         // Every snake when empty
+		died            map[string]death // Snakes that die this tick
+	}{
+		{
+			name: "head to head on the same cell",
+			snakes: map[string]Snake{
+				"a": {X: 1, Y: 1, Speed: right},
+				"b": {X: 3, Y: 1, Speed: left},
+			},
+			died: map[string]death{
+				"a": {DeathHeadOn, "b"},
+				"b": {DeathHeadOn, "a"},
+			},
+		},
+		{
+			name: "swapping cells",
+			snakes: map[string]Snake{
+				"a": {X: 1, Y: 1, Speed: right},
+				"b": {X: 2, Y: 1, Speed: left},
+			},
+			died: map[string]death{
+				"a": {DeathHeadOn, "b"},
+				"b": {DeathHeadOn, "a"},
+			},
+		},
+		{
+			name: "head on another snake's tail",
+			snakes: map[string]Snake{
+				"a": {X: 1, Y: 1, Speed: right},
+				"b": {X: 2, Y: 2, Speed: down, Size: 2, Tail: []Vector{{X: 2, Y: 0}, {X: 2, Y: 1}}},
+			},
+			died: map[string]death{
+				"a": {DeathCollision, "b"},
+			},
+		},
+		{
+			name: "head on a tail cell vacated this tick",
+			snakes: map[string]Snake{
+				"a": {X: 1, Y: 1, Speed: right},
+				"b": {X: 2, Y: 2, Speed: down, Size: 1, Tail: []Vector{{X: 2, Y: 1}}},
+			},
+		},
+		{
+			name: "head on its own tail",
+			snakes: map[string]Snake{
+				"a": {X: 2, Y: 2, Speed: up, Size: 4, Tail: []Vector{{X: 1, Y: 1}, {X: 2, Y: 1}, {X: 3, Y: 1}, {X: 3, Y: 2}}},
+			},
+			died: map[string]death{
+				"a": {DeathSelf, ""},
+			},
+		},
+		{
+			name: "head on a frozen head",
+			snakes: map[string]Snake{
+				"a": {X: 1, Y: 1, Speed: right},
+				"b": {X: 2, Y: 1, Speed: up},
+			},
+			moving: []string{"a"},
+			died: map[string]death{
+				"a": {DeathCollision, "b"},
+			},
+		},
+		{
+			name:  "head on a head stopped by a wall",
+			walls: true,
+			snakes: map[string]Snake{
+				"a": {X: 8, Y: 1, Speed: right},
+				"b": {X: 9, Y: 1, Speed: right},
+			},
+			died: map[string]death{
+				"a": {DeathCollision, "b"},
+				"b": {DeathWall, ""},
+			},
+		},
+		{
+			name: "head on a snake that was already dead",
+			snakes: map[string]Snake{
+				"a": {X: 1, Y: 1, Speed: right},
+				"b": {X: 2, Y: 2, Speed: down, Size: 2, Tail: []Vector{{X: 2, Y: 0}, {X: 2, Y: 1}}, IsDead: true},
+			},
+			moving: []string{"a"},
+		},
+		{
+			name: "server snake without ServerSnakeCollision",
+			snakes: map[string]Snake{
+				"a":      {X: 1, Y: 1, Speed: right},
+				"server": {X: 2, Y: 2, Speed: down, Size: 2, Tail: []Vector{{X: 2, Y: 0}, {X: 2, Y: 1}}, Type: "server"},
+			},
+		},
+		{
+			name:            "server snake with ServerSnakeCollision",
+			serverCollision: true,
+			snakes: map[string]Snake{
+				"a":      {X: 1, Y: 1, Speed: right},
+				"server": {X: 2, Y: 2, Speed: down, Size: 2, Tail: []Vector{{X: 2, Y: 0}, {X: 2, Y: 1}}, Type: "server"},
+			},
+			died: map[string]death{
+				"a": {DeathCollision, "server"},
+			},
+		},
+		{
+			name: "three heads on one cell",
+			snakes: map[string]Snake{
+				"c": {X: 2, Y: 0, Speed: down},
+				"b": {X: 3, Y: 1, Speed: left},
+				"a": {X: 1, Y: 1, Speed: right},
+			},
+			died: map[string]death{
+				"a": {DeathHeadOn, "b"},
+				"b": {DeathHeadOn, "a"},
+				"c": {DeathHeadOn, "a"},
+			},
+		},
+		{
+			name: "tail of one snake under the frozen head of another",
+			snakes: map[string]Snake{
+				"c": {X: 1, Y: 1, Speed: right},
+				"b": {X: 2, Y: 1, Speed: up},
+				"a": {X: 2, Y: 3, Speed: down, Size: 2, Tail: []Vector{{X: 2, Y: 1}, {X: 2, Y: 2}}},
+			},
+			moving: []string{"c"},
+			died: map[string]death{
+				"c": {DeathCollision, "a"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(previous bool) { ServerSnakeCollision = previous }(ServerSnakeCollision)
+			ServerSnakeCollision = tt.serverCollision
+
+			moving := tt.moving
+			if len(moving) == 0 {
+				moving = slices.Collect(maps.Keys(tt.snakes))
+			}
+			wasDead := make(map[string]bool)
+			for id, snake := range tt.snakes {
+				wasDead[id] = snake.IsDead
+			}
+
+			board := &Board{Size: 10, Walls: tt.walls}
+			result := board.Step(tt.snakes, moving)
+
+			var died []string
+			for _, id := range slices.Sorted(maps.Keys(tt.snakes)) {
+				snake := tt.snakes[id]
+				want, dies := tt.died[id]
+				if dies {
+					died = append(died, id)
+				}
+				if snake.IsDead != (dies || wasDead[id]) || snake.Cause != want.cause || snake.KilledBy != want.killer {
+					t.Errorf("%s: dead %t cause %q killer %q, want dead %t cause %q killer %q",
+						id, snake.IsDead, snake.Cause, snake.KilledBy, dies || wasDead[id], want.cause, want.killer)
+				}
+			}
+			if !slices.Equal(result.Died, died) {
+				t.Errorf("Died = %v, want %v", result.Died, died)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package game
+
+import "math/rand"
+
+// FoodTypes are the kinds of food placed on the board.
+var FoodTypes = []string{"redApple", "greenApple", "yellowApple", "banana", "cherry", "chili", "strawberry"}
+
+// FoodScore is what eating each kind of food adds to a snake's score.
+var FoodScore = map[string]int{
+	"redApple":    50,
+	"greenApple":  10,
+	"yellowApple": 100,
+	"chili":       700,
+	"strawberry":  1000,
+	"cherry":      30,
+	"banana":      500,
+}
+
+// NewFood places the food with the given index on a random cell of a board
+// with size cells along each side.
+func NewFood(rng *rand.Rand, size int, index int) []any {
+	x := rng.Intn(size)
+	y := rng.Intn(size)
+
+	typeIndex := rng.Intn(len(FoodTypes))
+	return []any{x, y, index, FoodTypes[typeIndex]}
+}
+
+// GenerateFood lays out count pieces of food.
+func GenerateFood(rng *rand.Rand, size int, count int) [][]any {
+	food := make([][]any, count)
+	for i := range count {
+		food[i] = NewFood(rng, size, i)
+	}
+	return food
+}
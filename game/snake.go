@@ -0,0 +1,168 @@
+// Package game holds the rules of the game: how snakes move and grow, where
+// food appears and who dies in a collision. It knows nothing about rooms or
+// connections, so the server and offline tools such as cmd/simulate play by
+// the same rules.
+package game
+
+import (
+	"maps"
+	"math/rand"
+	"slices"
+)
+
+type Vector struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type Snake struct {
+	X      int      `json:"x"`
+	Y      int      `json:"y"`
+	Speed  Vector   `json:"speed"`
+	Tail   []Vector `json:"tail"`
+	Size   int      `json:"size"`
+	IsDead bool     `json:"isDead"`
+	Score  int      `json:"score"`
+	Type   string   `json:"type"`
+
+	// Set when the snake dies
+	Cause    DeathCause `json:"-"`
+	KilledBy string     `json:"-"`
+}
+
+// DeathCause says how a snake's game ended.
+type DeathCause string
+
+const (
+	DeathSelf      DeathCause = "self"      // Ran into its own tail
+	DeathCollision DeathCause = "collision" // Ran into another snake's tail
+	DeathHeadOn    DeathCause = "head_on"   // Met another snake head to head, both die
+	DeathWall      DeathCause = "wall"      // Left the board in a mode with walls
+	DeathLeft      DeathCause = "left"      // The player left the game
+)
+
+// ServerSnakeCollision makes the server snake take part in collisions.
+var ServerSnakeCollision = false
+
+// Board is what the snakes move on.
+type Board struct {
+	Size  int        // Cells along each side
+	Walls bool       // Snakes die at the edge instead of wrapping around
+	Food  [][]any    // [x, y, index, food type] for each piece of food
+	Rng   *rand.Rand // Places new food
+}
+
+// StepResult is what happened on the board during a Step.
+type StepResult struct {
+	Eaten map[string]string // Food type eaten by each snake that ate
+	Food  [][]any           // Food placed again after being eaten
+	Died  []string          // Snakes that died, in ID order
+}
+
+// Turn points the snake in a new direction. Turns along the axis the snake
+// is moving on, i.e. reversing or repeating its direction, are refused.
+func (s *Snake) Turn(direction Vector) bool {
+	if (s.Speed.X != 0 && direction.X != 0) || (s.Speed.Y != 0 && direction.Y != 0) {
+		return false
+	}
+	s.Speed = direction
+	return true
+}
+
+// Update moves the snake and shifts its tail. It returns the type of food
+// eaten this step, if any, and the food put back on the board in its place.
+// Collisions are resolved for all snakes together once every snake has
+// moved, see Board.Step.
+func (s *Snake) Update(board *Board) (eaten string, food []any) {
+	if s.IsDead {
+		return "", nil
+	}
+	// Check if snake's head collides with any food
+	for i := range board.Food {
+		if s.X == board.Food[i][0] && s.Y == board.Food[i][1] {
+			s.Size++
+			s.Tail = append(s.Tail, Vector{X: s.X, Y: s.Y})
+
+			eaten = board.Food[i][3].(string)
+			s.Score += FoodScore[eaten]
+
+			food = NewFood(board.Rng, board.Size, board.Food[i][2].(int))
+			board.Food[i] = food
+			break
+		}
+	}
+
+	if s.Size == len(s.Tail) {
+		for i := range len(s.Tail) - 1 {
+			s.Tail[i] = s.Tail[i+1]
+		}
+	}
+
+	// Add current position to the end of the tail
+	if s.Size > 0 {
+		s.Tail[s.Size-1] = Vector{X: s.X, Y: s.Y}
+	}
+
+	// Move the snake
+	s.X += s.Speed.X
+	s.Y += s.Speed.Y
+
+	// With walls the snake dies at the edge instead of wrapping around
+	if board.Walls && (s.X < 0 || s.X >= board.Size || s.Y < 0 || s.Y >= board.Size) {
+		s.X -= s.Speed.X
+		s.Y -= s.Speed.Y
+		s.IsDead = true
+		s.Cause = DeathWall
+		return eaten, food
+	}
+
+	if s.X >= board.Size {
+		s.X = 0
+	} else if s.X < 0 {
+		s.X = board.Size
+	}
+
+	if s.Y >= board.Size {
+		s.Y = 0
+	} else if s.Y < 0 {
+		s.Y = board.Size
+	}
+
+	return eaten, food
+}
+
+// Step moves each snake in moving one cell, in ID order, then resolves
+// collisions as if they had all moved at once. The other snakes stand still.
+// snakes is updated in place.
+func (b *Board) Step(snakes map[string]Snake, moving []string) StepResult {
+	result := StepResult{Eaten: make(map[string]string)}
+
+	// Move every snake first, remembering where its head was
+	moved := make(map[string]Vector, len(moving))
+	for _, id := range slices.Sorted(slices.Values(moving)) {
+		snake := snakes[id]
+		moved[id] = Vector{X: snake.X, Y: snake.Y}
+		eaten, food := snake.Update(b)
+		snakes[id] = snake
+		if eaten != "" {
+			result.Eaten[id] = eaten
+			result.Food = append(result.Food, food)
+		}
+	}
+
+	// Then work out who died, so the order snakes moved in does not matter
+	deaths := resolveCollisions(snakes, moved)
+	for _, id := range slices.Sorted(maps.Keys(moved)) {
+		snake := snakes[id]
+		if death, died := deaths[id]; died {
+			snake.IsDead = true
+			snake.Cause = death.cause
+			snake.KilledBy = death.killer
+			snakes[id] = snake
+		}
+		if snake.IsDead {
+			result.Died = append(result.Died, id)
+		}
+	}
+	return result
+}
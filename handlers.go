@@ -0,0 +1,283 @@
+package main
+
+import (
+	"log"
+
+	"github.com/gorilla/websocket"
+)
+
+// events holds the handler for every event a client can send.
+var events = newEvents()
+
+func newEvents() *EventRegistry {
+	registry := NewEventRegistry()
+	registry.Use(RateLimit(30, 60))
+
+	registry.Handle("p", handleLegacyPing)
+	registry.Handle("ping", Typed(handlePing))
+	registry.Handle("resync", handleResync)
+
+	registry.Handle("move", Typed(handleMove), PlayersOnly, RequireRoom)
+	registry.Handle("newPlayer", Typed(handleNewPlayer), LogEvents, PlayersOnly, RequireRoom, BeforeGameStart)
+	registry.Handle("updatePlayer", Typed(handleUpdatePlayer), PlayersOnly, RequireRoom, BeforeGameStart)
+	registry.Handle("ready", Typed(handleReady), PlayersOnly, RequireRoom)
+	registry.Handle("waitingRoomStatus", handleWaitingRoomStatus, PlayersOnly, RequireRoom)
+	registry.Handle("startGame", handleStartGame, LogEvents, PlayersOnly, RequireRoom, HostOnly, BeforeGameStart)
+	registry.Handle("kickPlayer", Typed(handleKickPlayer), LogEvents, PlayersOnly, RequireRoom, HostOnly, BeforeGameStart)
+	registry.Handle("transferHost", Typed(handleTransferHost), LogEvents, PlayersOnly, RequireRoom, HostOnly)
+	registry.Handle("rematch", handleRematch, LogEvents, PlayersOnly, RequireRoom)
+	registry.Handle("createRoom", Typed(handleCreateRoom), LogEvents, PlayersOnly)
+	registry.Handle("joinRoom", Typed(handleJoinRoom), LogEvents, PlayersOnly)
+
+	registry.Handle("spectate", Typed(handleSpectate), LogEvents, SpectatorsOnly)
+	registry.Handle("replaySpeed", Typed(handleReplaySpeed), LogEvents, SpectatorsOnly, RequireRoom)
+
+	registry.Handle("listRooms", handleListRooms)
+	registry.Handle("subscribeRooms", handleSubscribeRooms)
+	registry.Handle("unsubscribeRooms", handleUnsubscribeRooms)
+
+	return registry
+}
+
+type PingPayload struct {
+	ClientTime int64 `json:"clientTime"`
+}
+
+type MovePayload struct {
+	Key string `json:"key"`
+}
+
+func (p *MovePayload) Validate() error {
+	if _, ok := directionMap[p.Key]; !ok {
+		return eventError(ErrInvalidDirection, "Unknown direction %s", p.Key)
+	}
+	return nil
+}
+
+type PlayerPayload struct {
+	Player Player `json:"player"`
+}
+
+// ReadyPayload sets the player's ready flag; it defaults to ready.
+type ReadyPayload struct {
+	Ready *bool `json:"ready"`
+}
+
+// TargetPayload names the player an event acts on.
+type TargetPayload struct {
+	ID string `json:"id"`
+}
+
+func (p *TargetPayload) Validate() error {
+	if p.ID == "" {
+		return eventError(ErrBadMessage, "Player id is required")
+	}
+	return nil
+}
+
+type CreateRoomPayload struct {
+	Mode     string `json:"mode"`
+	Password string `json:"password"`
+}
+
+func (p *CreateRoomPayload) Validate() error {
+	if _, exists := gameModeFor(p.Mode); !exists {
+		return eventError(ErrBadMessage, "Unknown game mode %s", p.Mode)
+	}
+	return nil
+}
+
+type JoinRoomPayload struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+func (p *JoinRoomPayload) Validate() error {
+	if p.Code == "" {
+		return eventError(ErrBadMessage, "Join code is required")
+	}
+	return nil
+}
+
+// SpectatePayload names the room to watch. Private rooms also need their
+// join code and password.
+type SpectatePayload struct {
+	ID       string `json:"id"`
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+func (p *SpectatePayload) Validate() error {
+	if p.ID == "" {
+		return eventError(ErrBadMessage, "Room id is required")
+	}
+	return nil
+}
+
+// ReplaySpeedPayload changes how fast a replay plays, as a multiple of its
+// recorded speed.
+type ReplaySpeedPayload struct {
+	Speed float64 `json:"speed"`
+}
+
+func (p *ReplaySpeedPayload) Validate() error {
+	if !validReplaySpeed(p.Speed) {
+		return eventError(ErrBadMessage, "Replay speed must be between %g and %g", minReplaySpeed, maxReplaySpeed)
+	}
+	return nil
+}
+
+// handleLegacyPing answers the plain "p" text ping.
+func handleLegacyPing(ctx *EventContext) error {
+	ctx.Client.enqueue(websocket.TextMessage, "p", []byte("p"))
+	return nil
+}
+
+func handlePing(ctx *EventContext, payload PingPayload) error {
+	sendPong(ctx.Client, payload.ClientTime)
+	return nil
+}
+
+func handleResync(ctx *EventContext) error {
+	ctx.Client.needsKeyframe.Store(true)
+	return nil
+}
+
+// handleMove queues a direction change; the game loop applies one per tick.
+func handleMove(ctx *EventContext, payload MovePayload) error {
+	speed := directionMap[payload.Key]
+	ctx.Room.queueInput(ctx.Client.playerId, Vector{X: speed.X, Y: speed.Y})
+	return nil
+}
+
+// handleNewPlayer puts the client in the waiting room. Only the name and
+// colours come from the client, the snake is the server's.
+func handleNewPlayer(ctx *EventContext, payload PlayerPayload) error {
+	client := ctx.Client
+
+	name := payload.Player.Name
+	if !client.identity.Guest || name == "" {
+		name = client.identity.Name
+	}
+	log.Printf("New player joined: %s", name)
+	player := Player{
+		ID:      client.playerId,
+		Name:    name,
+		Colours: payload.Player.Colours,
+		Type:    "player",
+		Snake: Snake{
+			Speed: Vector{X: 1, Y: 0},
+			Tail:  []Vector{},
+		},
+	}
+
+	if err := ctx.Room.addPlayer(client, player); err != nil {
+		return err
+	}
+	log.Printf("Config sent to player %s", client.playerId)
+	return nil
+}
+
+func handleUpdatePlayer(ctx *EventContext, payload PlayerPayload) error {
+	return ctx.Room.updateColours(ctx.Client.playerId, payload.Player.Colours)
+}
+
+func handleReady(ctx *EventContext, payload ReadyPayload) error {
+	ready := payload.Ready == nil || *payload.Ready
+	return ctx.Room.setReady(ctx.Client.playerId, ready)
+}
+
+func handleWaitingRoomStatus(ctx *EventContext) error {
+	log.Printf("Sending waiting room status to room: %s", ctx.Room.id)
+	ctx.Room.requestWaitingRoomStatus()
+	return nil
+}
+
+func handleStartGame(ctx *EventContext) error {
+	return ctx.Room.start()
+}
+
+func handleRematch(ctx *EventContext) error {
+	return ctx.Room.voteRematch(ctx.Client.playerId)
+}
+
+// handleKickPlayer removes a player from the waiting room and ends their
+// session, so they cannot come back with their resume token.
+func handleKickPlayer(ctx *EventContext, payload TargetPayload) error {
+	if payload.ID == ctx.Client.playerId {
+		return eventError(ErrNotAllowed, "The host cannot kick themselves")
+	}
+
+	kicked, err := ctx.Room.kick(payload.ID)
+	if err != nil {
+		return err
+	}
+	endSession(payload.ID)
+	if kicked != nil {
+		kicked.closeWith(websocket.ClosePolicyViolation, "Kicked by the host")
+	}
+	return nil
+}
+
+func handleTransferHost(ctx *EventContext, payload TargetPayload) error {
+	return ctx.Room.transferHost(payload.ID)
+}
+
+// handleCreateRoom moves the player into a new private room.
+func handleCreateRoom(ctx *EventContext, payload CreateRoomPayload) error {
+	mode, _ := gameModeFor(payload.Mode)
+	room := roomManager.CreatePrivate(mode, payload.Password, ctx.Client)
+	switchRoom(ctx.Client, ctx.Room, room)
+	return nil
+}
+
+// handleJoinRoom moves the player into the private room with the join code.
+func handleJoinRoom(ctx *EventContext, payload JoinRoomPayload) error {
+	room, err := findPrivateRoom(payload.Code, payload.Password)
+	if err != nil {
+		return err
+	}
+	if room == ctx.Room {
+		return nil
+	}
+	if !room.join(ctx.Client) {
+		return eventError(ErrRoomFull, "Room %s is full or has started", payload.Code)
+	}
+	switchRoom(ctx.Client, ctx.Room, room)
+	return nil
+}
+
+func handleSpectate(ctx *EventContext, payload SpectatePayload) error {
+	room, err := findSpectatedRoom(payload.ID, payload.Code, payload.Password)
+	if err != nil {
+		return err
+	}
+	if !spectateRoom(ctx.Client, room) {
+		return eventError(ErrRoomNotFound, "Room %s not found", payload.ID)
+	}
+	return nil
+}
+
+func handleReplaySpeed(ctx *EventContext, payload ReplaySpeedPayload) error {
+	return ctx.Room.setPlaybackSpeed(payload.Speed)
+}
+
+func handleListRooms(ctx *EventContext) error {
+	ctx.Client.send(RoomListMessage{
+		Event: "rooms",
+		Rooms: roomManager.PublicRooms(),
+	})
+	return nil
+}
+
+// handleSubscribeRooms sends the room list and then a roomUpdate or
+// roomRemoved for every change until the client unsubscribes.
+func handleSubscribeRooms(ctx *EventContext) error {
+	roomList.subscribe(ctx.Client)
+	return handleListRooms(ctx)
+}
+
+func handleUnsubscribeRooms(ctx *EventContext) error {
+	roomList.unsubscribe(ctx.Client)
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"slices"
+)
+
+// The host is the first player in a room. Only the host may start the game,
+// kick players and hand the role to someone else. When the host's connection
+// leaves, the role passes to the next connected player.
+
+// setHost makes playerId the host and tells the room.
+func (r *Room) setHost(playerId string) {
+	if r.hostId == playerId {
+		return
+	}
+	r.hostId = playerId
+	log.Printf("Player %q is now host of room %s", playerId, r.id)
+
+	r.broadcast(&HostChangedMessage{
+		Event:  "hostChanged",
+		HostID: playerId,
+	})
+	if r.state == RoomWaiting || r.state == RoomCountdown {
+		r.broadcastWaitingRoomStatus()
+	}
+}
+
+// migrateHost passes the role on if the host no longer has a connection in
+// the room.
+func (r *Room) migrateHost() {
+	if r.connected(r.hostId) {
+		return
+	}
+	if len(r.players) == 0 {
+		r.setHost("")
+		return
+	}
+	r.setHost(r.players[0].playerId)
+}
+
+func (r *Room) connected(playerId string) bool {
+	return slices.ContainsFunc(r.players, func(client *Client) bool {
+		return client.playerId == playerId
+	})
+}
+
+func (r *Room) isHost(playerId string) bool {
+	host := false
+	r.do(func() {
+		host = r.hostId == playerId
+	})
+	return host
+}
+
+// kick removes a player from the room and bars them from joining it again.
+// It returns the player's connection, if they have one, so the caller can
+// close it.
+func (r *Room) kick(playerId string) (*Client, error) {
+	var kicked *Client
+	var err error
+	ok := r.do(func() {
+		i := slices.IndexFunc(r.players, func(client *Client) bool {
+			return client.playerId == playerId
+		})
+		_, waiting := r.waitingRoom[playerId]
+		if i < 0 && !waiting {
+			err = eventError(ErrPlayerNotFound, "Player %s is not in this room", playerId)
+			return
+		}
+
+		if i >= 0 {
+			kicked = r.players[i]
+			r.players = slices.Delete(r.players, i, i+1)
+		}
+		r.kicked[playerId] = true
+		delete(r.inputs, playerId)
+		r.removeFromWaitingRoom(playerId)
+		r.broadcastWaitingRoomStatus()
+		r.updateWaitingTimeout()
+		r.checkReady()
+		log.Printf("Player %s kicked from room %s", playerId, r.id)
+	})
+	if !ok {
+		return nil, eventError(ErrRoomNotFound, "Room %s not found", r.id)
+	}
+	return kicked, err
+}
+
+// transferHost hands the host role to another connected player.
+func (r *Room) transferHost(playerId string) error {
+	var err error
+	ok := r.do(func() {
+		if !r.connected(playerId) {
+			err = eventError(ErrPlayerNotFound, "Player %s is not in this room", playerId)
+			return
+		}
+		r.setHost(playerId)
+	})
+	if !ok {
+		return eventError(ErrRoomNotFound, "Room %s not found", r.id)
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package main
+
+// queueInput buffers a direction change for the player's snake. When the
+// queue is full the newest input is dropped so earlier keypresses keep their
+// order.
+func (r *Room) queueInput(playerId string, direction Vector) {
+	r.do(func() {
+		if len(r.inputs[playerId]) >= Settings.InputQueueDepth {
+			return
+		}
+		r.inputs[playerId] = append(r.inputs[playerId], direction)
+	})
+}
+
+// applyNextInput turns the snake according to the first valid queued input.
+// Inputs on the axis the snake travelled along last tick, i.e. reversing or
+// repeating the current direction, are discarded.
+func (r *Room) applyNextInput(playerId string, snake *Snake) {
+	queue := r.inputs[playerId]
+	for len(queue) > 0 {
+		direction := queue[0]
+		queue = queue[1:]
+
+		if snake.Turn(direction) {
+			r.recordEvent(r.tick, playerId, ReplayTurn, &direction)
+			break
+		}
+	}
+	r.inputs[playerId] = queue
+}
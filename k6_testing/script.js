@@ -1,4 +1,5 @@
 import ws from 'k6/ws';
+import http from 'k6/http';
 import { check, sleep } from 'k6';
 
 export let options = {
@@ -8,8 +9,9 @@ export let options = {
 };
 
 export default function () {
-    let playerId = Math.random().toString(36).substring(2, 2 + 12);
-    const url = `ws://192.168.4.29:4002/ws?playerId=${playerId}`;
+    const auth = http.post('http://192.168.4.29:4002/auth/token').json();
+    const playerId = auth.playerId;
+    const url = `ws://192.168.4.29:4002/ws?token=${auth.token}`;
     const params = {
         tags: { my_tag: 'testing' },
     };
@@ -60,7 +62,7 @@ export default function () {
             if (parsed.event === 'startGame') {
                 socket.setInterval(function timeout() {
                     const move = ['u', 'd', 'l', 'r'][Math.floor(Math.random() * 4)]
-                    socket.send(`m:${playerId}:u`)
+                    socket.send(`m:${move}`)
                     console.log(`Moving ${move}`);
                 }, 3000);
             }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// A waiting room starts on its own once enough players are ready, or when
+// the lobby timer runs out with at least MinPlayers in it. Either way the
+// game starts after a countdown.
+
+// updateWaitingTimeout arms the timer that fits the waiting room: the abandon
+// timeout while nobody is connected, the lobby timer once MinPlayers are in
+// the waiting room, and none otherwise. A timer that is already running for
+// the same reason is left alone.
+func (r *Room) updateWaitingTimeout() {
+	if r.state != RoomWaiting {
+		return
+	}
+
+	switch {
+	case len(r.players) == 0:
+		if r.timeout == nil || r.lobbyTimer {
+			r.armTimeout(Settings.AbandonedRoomTimeout)
+		}
+	case len(r.waitingRoom) >= r.mode.MinPlayers:
+		if r.timeout == nil || !r.lobbyTimer {
+			r.armTimeout(Settings.LobbyTimeout)
+			r.lobbyTimer = true
+		}
+	default:
+		r.stopTimeout()
+	}
+}
+
+// handleLobbyTimeout closes an abandoned room, or starts the countdown when
+// the lobby timer runs out.
+func (r *Room) handleLobbyTimeout() {
+	switch {
+	case len(r.players) == 0:
+		log.Printf("Room %s abandoned", r.id)
+		r.setState(RoomClosed)
+	case len(r.waitingRoom) >= r.mode.MinPlayers:
+		log.Printf("Lobby timer ran out in room %s", r.id)
+		r.setState(RoomCountdown)
+	}
+}
+
+func (r *Room) readyCount() int {
+	ready := 0
+	for _, player := range r.waitingRoom {
+		if player.Ready {
+			ready++
+		}
+	}
+	return ready
+}
+
+// quorumReady reports whether enough players are in the waiting room and
+// enough of them are ready to start.
+func (r *Room) quorumReady() bool {
+	if len(r.waitingRoom) < r.mode.MinPlayers {
+		return false
+	}
+	needed := int(math.Ceil(r.mode.ReadyQuorum * float64(len(r.waitingRoom))))
+	return r.readyCount() >= needed
+}
+
+// checkReady starts the countdown once the ready quorum is reached, and calls
+// it off if players leave and too few are left to play.
+func (r *Room) checkReady() {
+	switch {
+	case r.state == RoomWaiting && r.quorumReady():
+		log.Printf("Players ready in room %s", r.id)
+		r.setState(RoomCountdown)
+	case r.state == RoomCountdown && len(r.waitingRoom) < r.mode.MinPlayers:
+		r.cancelCountdown()
+	}
+}
+
+func (r *Room) cancelCountdown() {
+	log.Printf("Countdown called off in room %s", r.id)
+	r.setState(RoomWaiting)
+}
+
+// startCountdown begins the countdown to the first tick.
+func (r *Room) startCountdown() {
+	r.countdownLeft = Settings.CountdownDuration
+	r.countdownStep()
+}
+
+// countdownStep broadcasts the whole seconds left and waits for the next one,
+// or starts the game when the countdown is over.
+func (r *Room) countdownStep() {
+	if r.countdownLeft <= 0 {
+		r.setState(RoomPlaying)
+		return
+	}
+
+	seconds := int(math.Ceil(r.countdownLeft.Seconds()))
+	r.broadcast(&CountdownMessage{
+		Event:   "countdown",
+		Seconds: seconds,
+	})
+
+	// The first step may be shorter so the rest land on whole seconds
+	step := r.countdownLeft - time.Duration(seconds-1)*time.Second
+	r.countdownLeft -= step
+	r.armTimeout(step)
+}
+
+// setReady marks a player in the waiting room as ready or not.
+func (r *Room) setReady(playerId string, ready bool) error {
+	var err error
+	ok := r.do(func() {
+		if r.state != RoomWaiting && r.state != RoomCountdown {
+			err = eventError(ErrGameStarted, "The game has already started")
+			return
+		}
+		player, exists := r.waitingRoom[playerId]
+		if !exists {
+			err = eventError(ErrNotInWaitingRoom, "Player not found in waiting room")
+			return
+		}
+		player.Ready = ready
+		r.waitingRoom[playerId] = player
+		r.broadcastWaitingRoomStatus()
+		if r.state == RoomCountdown && !ready {
+			// A player who is not ready after all stops the countdown
+			r.cancelCountdown()
+			return
+		}
+		r.checkReady()
+	})
+	if !ok {
+		return eventError(ErrRoomNotFound, "Room %s not found", r.id)
+	}
+	return err
+}
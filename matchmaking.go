@@ -0,0 +1,248 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MatchStrategy decides which open room a player is put in.
+type MatchStrategy string
+
+const (
+	FillOldest   MatchStrategy = "fill_oldest"   // The room that has been waiting longest
+	BalanceSkill MatchStrategy = "balance_skill" // The room whose players are closest in skill
+	JoinFriends  MatchStrategy = "join_friends"  // A friend's room, otherwise the oldest
+)
+
+// GameMode is a set of room rules a player asks for with ?mode=.
+type GameMode struct {
+	Name        string        `json:"name"`
+	Capacity    int           `json:"capacity"`   // Players a room holds
+	MinPlayers  int           `json:"minPlayers"` // Players needed in the waiting room to start
+	Strategy    MatchStrategy `json:"strategy"`
+	Queue       bool          `json:"queue"`       // Hold players until MinPlayers can start a room together
+	ReadyQuorum float64       `json:"readyQuorum"` // Share of the waiting room that must be ready to start
+	Walls       bool          `json:"walls"`       // Snakes die at the edge instead of wrapping around
+}
+
+const defaultGameMode = "classic"
+
+var gameModes = map[string]*GameMode{
+	"classic": {Name: "classic", Capacity: 2, MinPlayers: 1, Strategy: FillOldest, ReadyQuorum: 1},
+	"duel":    {Name: "duel", Capacity: 2, MinPlayers: 2, Strategy: BalanceSkill, Queue: true, ReadyQuorum: 1, Walls: true},
+	"party":   {Name: "party", Capacity: 4, MinPlayers: 2, Strategy: JoinFriends, ReadyQuorum: 0.75},
+}
+
+// gameModeFor returns the named mode, or the default one for an empty name.
+func gameModeFor(name string) (*GameMode, bool) {
+	if name == "" {
+		name = defaultGameMode
+	}
+	mode, exists := gameModes[name]
+	return mode, exists
+}
+
+// loadGameModes adds or replaces modes from a JSON list such as
+// [{"name":"duel","capacity":2,"minPlayers":2,"strategy":"balance_skill","queue":true}].
+func loadGameModes(value string) {
+	if value == "" {
+		return
+	}
+
+	var modes []GameMode
+	if err := json.Unmarshal([]byte(value), &modes); err != nil {
+		log.Printf("Invalid GAME_MODES: %v", err)
+		return
+	}
+
+	for _, mode := range modes {
+		if mode.ReadyQuorum == 0 {
+			mode.ReadyQuorum = 1
+		}
+		if !mode.valid() {
+			log.Printf("Invalid game mode %q, ignoring it", mode.Name)
+			continue
+		}
+		gameModes[mode.Name] = &mode
+	}
+}
+
+func (m *GameMode) valid() bool {
+	switch m.Strategy {
+	case FillOldest, BalanceSkill, JoinFriends:
+	default:
+		return false
+	}
+	return m.Name != "" &&
+		m.Capacity >= 1 && m.Capacity <= len(startingPositions) &&
+		m.MinPlayers >= 1 && m.MinPlayers <= m.Capacity &&
+		m.ReadyQuorum > 0 && m.ReadyQuorum <= 1
+}
+
+// matchTicket is a player waiting in a mode's queue.
+type matchTicket struct {
+	client *Client
+	placed chan *Room
+}
+
+// Matchmaker puts players in rooms and holds them in a queue when a mode asks
+// for enough players to be found before a room is created.
+type Matchmaker struct {
+	mutex  sync.Mutex
+	queues map[string][]*matchTicket // Per mode, oldest first
+}
+
+var matchmaker = &Matchmaker{queues: make(map[string][]*matchTicket)}
+
+// Match finds the client a room in the given mode, preferring the rooms of
+// the given friends for JoinFriends. In queued modes it blocks until enough
+// players are waiting or Settings.MatchmakingTimeout passes. Returns nil if
+// the client went away while queued.
+func (mm *Matchmaker) Match(client *Client, mode *GameMode, friends []string) *Room {
+	if room := mm.findOpenRoom(client, mode, friends); room != nil {
+		log.Printf("Player %s joined room %s", client.playerId, room.id)
+		return room
+	}
+
+	if mode.Queue && mode.MinPlayers > 1 {
+		return mm.wait(client, mode)
+	}
+	return mm.createRoom(client, mode)
+}
+
+// findOpenRoom tries the waiting rooms of the mode in the order its strategy
+// prefers and returns the first one that takes the client.
+func (mm *Matchmaker) findOpenRoom(client *Client, mode *GameMode, friends []string) *Room {
+	candidates := []*Room{}
+	for _, room := range roomManager.Rooms() {
+		if room.mode == mode && !room.private {
+			candidates = append(candidates, room)
+		}
+	}
+	slices.SortFunc(candidates, func(a, b *Room) int {
+		return a.createdAt.Compare(b.createdAt)
+	})
+
+	switch mode.Strategy {
+	case BalanceSkill:
+		skill := ratings.Get(client.playerId)
+		gaps := make(map[*Room]int, len(candidates))
+		for _, room := range candidates {
+			gaps[room] = abs(room.averageSkill() - skill)
+		}
+		slices.SortStableFunc(candidates, func(a, b *Room) int {
+			return cmp.Compare(gaps[a], gaps[b])
+		})
+	case JoinFriends:
+		friendRooms := make(map[string]bool)
+		for _, friend := range friends {
+			if roomId, exists := sessionRoomId(friend); exists {
+				friendRooms[roomId] = true
+			}
+		}
+		slices.SortStableFunc(candidates, func(a, b *Room) int {
+			if friendRooms[a.id] == friendRooms[b.id] {
+				return 0
+			}
+			if friendRooms[a.id] {
+				return -1
+			}
+			return 1
+		})
+	}
+
+	for _, room := range candidates {
+		if room.join(client) {
+			return room
+		}
+	}
+	return nil
+}
+
+func (mm *Matchmaker) createRoom(client *Client, mode *GameMode) *Room {
+	room := roomManager.Create(mode, client)
+	log.Printf("Player %s created new %s room: %s", client.playerId, mode.Name, room.id)
+	return room
+}
+
+// wait queues the client until MinPlayers players of the mode are waiting,
+// then puts them all in a new room.
+func (mm *Matchmaker) wait(client *Client, mode *GameMode) *Room {
+	ticket := &matchTicket{client: client, placed: make(chan *Room, 1)}
+
+	mm.mutex.Lock()
+	queue := append(mm.queues[mode.Name], ticket)
+	var matched []*matchTicket
+	if len(queue) >= mode.MinPlayers {
+		matched = queue[:min(len(queue), mode.Capacity)]
+		queue = slices.Clone(queue[len(matched):])
+	}
+	mm.queues[mode.Name] = queue
+	waiting := len(queue)
+	mm.mutex.Unlock()
+
+	if matched != nil {
+		clients := make([]*Client, len(matched))
+		for i, ticket := range matched {
+			clients[i] = ticket.client
+		}
+		room := roomManager.Create(mode, clients...)
+		for _, ticket := range matched {
+			ticket.placed <- room
+		}
+		log.Printf("Matched %d players into %s room %s", len(matched), mode.Name, room.id)
+	} else {
+		log.Printf("Player %s queued for %s (%d/%d)", client.playerId, mode.Name, waiting, mode.MinPlayers)
+		client.send(QueuedMessage{
+			Event:   "queued",
+			Mode:    mode.Name,
+			Waiting: waiting,
+			Needed:  mode.MinPlayers,
+		})
+	}
+
+	timeout := time.NewTimer(Settings.MatchmakingTimeout)
+	defer timeout.Stop()
+
+	select {
+	case room := <-ticket.placed:
+		return room
+	case <-client.done:
+		if mm.cancel(mode, ticket) {
+			return nil
+		}
+	case <-timeout.C:
+		if mm.cancel(mode, ticket) {
+			log.Printf("No match for player %s in %s, starting a room anyway", client.playerId, mode.Name)
+			return mm.createRoom(client, mode)
+		}
+	}
+	// Matched while giving up, the room already holds the client
+	return <-ticket.placed
+}
+
+// cancel takes the ticket out of the queue. It reports false if the ticket
+// has already been matched.
+func (mm *Matchmaker) cancel(mode *GameMode, ticket *matchTicket) bool {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	queue := mm.queues[mode.Name]
+	i := slices.Index(queue, ticket)
+	if i < 0 {
+		return false
+	}
+	mm.queues[mode.Name] = slices.Delete(queue, i, i+1)
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+)
+
+// A small MessagePack encoder and decoder covering the types JSON can express.
+// Messages go through their JSON form first so the json struct tags stay the
+// single source of truth for field names on both protocols.
+
+var errShortMsgpack = errors.New("msgpack: unexpected end of data")
+var errDeepMsgpack = errors.New("msgpack: nested too deeply")
+
+// Arrays and maps nested deeper than this are refused, so a hostile frame
+// cannot exhaust the stack. Messages nest a handful of levels at most.
+const maxMsgpackDepth = 32
+
+func marshalMsgpack(v any) ([]byte, error) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	return appendMsgpack(nil, generic)
+}
+
+func unmarshalMsgpack(data []byte, v any) error {
+	generic, rest, err := readMsgpack(data, 0)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("msgpack: %d trailing bytes", len(rest))
+	}
+
+	jsonBytes, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonBytes, v)
+}
+
+func appendMsgpack(buf []byte, v any) ([]byte, error) {
+	switch value := v.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if value {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return appendMsgpackInt(buf, n), nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, 0xcb)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(f)), nil
+	case string:
+		return appendMsgpackString(buf, value), nil
+	case []any:
+		buf = appendMsgpackHeader(buf, len(value), 0x90, 0xdc, 0xdd)
+		for _, item := range value {
+			var err error
+			if buf, err = appendMsgpack(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]any:
+		buf = appendMsgpackHeader(buf, len(value), 0x80, 0xde, 0xdf)
+		// Sorted keys keep the encoding deterministic
+		for _, key := range slices.Sorted(maps.Keys(value)) {
+			buf = appendMsgpackString(buf, key)
+			var err error
+			if buf, err = appendMsgpack(buf, value[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+}
+
+func appendMsgpackInt(buf []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= 0x7f:
+		return append(buf, byte(n))
+	case n < 0 && n >= -32:
+		return append(buf, byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		return append(buf, 0xd0, byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(n))
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n <= 31:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+// appendMsgpackHeader writes an array or map length using the fix, 16 and 32
+// bit forms.
+func appendMsgpackHeader(buf []byte, n int, fix, code16, code32 byte) []byte {
+	switch {
+	case n <= 15:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, code16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, code32), uint32(n))
+}
+
+// readMsgpack decodes one value into the same shapes encoding/json produces
+// and returns the remaining bytes. depth is how many arrays and maps the value
+// is inside of.
+func readMsgpack(data []byte, depth int) (any, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errShortMsgpack
+	}
+	code, data := data[0], data[1:]
+
+	switch {
+	case code <= 0x7f:
+		return int64(code), data, nil
+	case code >= 0xe0:
+		return int64(int8(code)), data, nil
+	case code&0xe0 == 0xa0:
+		return readMsgpackString(data, int(code&0x1f))
+	case code&0xf0 == 0x90:
+		return readMsgpackArray(data, int(code&0x0f), depth+1)
+	case code&0xf0 == 0x80:
+		return readMsgpackMap(data, int(code&0x0f), depth+1)
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, data, nil
+	case 0xc2:
+		return false, data, nil
+	case 0xc3:
+		return true, data, nil
+	case 0xca:
+		raw, data, err := readMsgpackUint(data, 4)
+		return float64(math.Float32frombits(uint32(raw))), data, err
+	case 0xcb:
+		raw, data, err := readMsgpackUint(data, 8)
+		return math.Float64frombits(raw), data, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		raw, data, err := readMsgpackUint(data, 1<<(code-0xcc))
+		return raw, data, err
+	case 0xd0:
+		raw, data, err := readMsgpackUint(data, 1)
+		return int64(int8(raw)), data, err
+	case 0xd1:
+		raw, data, err := readMsgpackUint(data, 2)
+		return int64(int16(raw)), data, err
+	case 0xd2:
+		raw, data, err := readMsgpackUint(data, 4)
+		return int64(int32(raw)), data, err
+	case 0xd3:
+		raw, data, err := readMsgpackUint(data, 8)
+		return int64(raw), data, err
+	case 0xd9, 0xda, 0xdb:
+		n, data, err := readMsgpackUint(data, 1<<(code-0xd9))
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgpackString(data, int(n))
+	case 0xdc, 0xdd:
+		n, data, err := readMsgpackUint(data, 2<<(code-0xdc))
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgpackArray(data, int(n), depth+1)
+	case 0xde, 0xdf:
+		n, data, err := readMsgpackUint(data, 2<<(code-0xde))
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgpackMap(data, int(n), depth+1)
+	}
+	return nil, nil, fmt.Errorf("msgpack: unsupported code 0x%02x", code)
+}
+
+func readMsgpackUint(data []byte, size int) (uint64, []byte, error) {
+	if len(data) < size {
+		return 0, nil, errShortMsgpack
+	}
+	var n uint64
+	for _, b := range data[:size] {
+		n = n<<8 | uint64(b)
+	}
+	return n, data[size:], nil
+}
+
+func readMsgpackString(data []byte, n int) (any, []byte, error) {
+	if len(data) < n {
+		return nil, nil, errShortMsgpack
+	}
+	return string(data[:n]), data[n:], nil
+}
+
+func readMsgpackArray(data []byte, n int, depth int) (any, []byte, error) {
+	if depth > maxMsgpackDepth {
+		return nil, nil, errDeepMsgpack
+	}
+	// Every element takes at least one byte, which bounds bogus lengths
+	if len(data) < n {
+		return nil, nil, errShortMsgpack
+	}
+	items := make([]any, n)
+	for i := range n {
+		var err error
+		if items[i], data, err = readMsgpack(data, depth); err != nil {
+			return nil, nil, err
+		}
+	}
+	return items, data, nil
+}
+
+func readMsgpackMap(data []byte, n int, depth int) (any, []byte, error) {
+	if depth > maxMsgpackDepth {
+		return nil, nil, errDeepMsgpack
+	}
+	if len(data) < 2*n {
+		return nil, nil, errShortMsgpack
+	}
+	values := make(map[string]any, n)
+	for range n {
+		key, rest, err := readMsgpack(data, depth)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyString, ok := key.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("msgpack: map key of type %T", key)
+		}
+		if values[keyString], data, err = readMsgpack(rest, depth); err != nil {
+			return nil, nil, err
+		}
+	}
+	return values, data, nil
+}
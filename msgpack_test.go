@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	frame := Frame{Tick: 1234, ServerTime: 1718000000123}
+	score := -5
+	disconnected := true
+	player := Player{
+		Name:    "Ann",
+		ID:      "p1",
+		Snake:   Snake{X: 3, Y: -1, Speed: Vector{X: 0, Y: -1}, Tail: []Vector{{X: 3, Y: 0}, {X: 3, Y: 1}}, Size: 2, Score: 150, Type: "player"},
+		Colours: Colours{Body: "#00ff00", Head: "red", Eyes: "white"},
+		Ready:   true,
+	}
+	summary := RoomSummary{ID: "room_1", Mode: "duel", State: RoomPlaying, Players: 2, Capacity: 2, CreatedAt: 1718000000000, Elapsed: 42}
+	mode := *gameModes["party"]
+
+	messages := []any{
+		&Message{Event: "move", RequestID: "r1"},
+		&EventMessage{Event: "startGame", Frame: frame},
+		&SnakeUpdateMessage{Event: "snake_update", Frame: frame, SnakesMap: map[string]Player{"p1": player, "p2": {ID: "p2", Snake: Snake{Tail: []Vector{}, IsDead: true}}}},
+		&ConfigMessage{Event: "config", Frame: frame, Config: &GameConfigJSON, Food: [][]any{{1.0, 2.0, 0.0, "apple"}, {39.0, 0.0, 1.0, "chili"}}, Mode: &mode},
+		&FoodUpdateMessage{Event: "updateFood", Frame: frame, Food: [][]any{{5.0, 6.0, 2.0, "banana"}}},
+		&WaitingRoomStatusMessage{Event: "waitingRoomStatus", Frame: frame, Players: []Player{player}, HostID: "p1"},
+		&SessionMessage{Event: "session", PlayerID: "p1", RoomID: "room_1", ResumeToken: "abc", Resumed: true, Mode: "classic", Private: true, JoinCode: "K7QX"},
+		&SnakeDeltaMessage{Event: "snake_delta", Frame: frame, BaseTick: 1233, Snakes: []SnakeDelta{
+			{ID: "p1", Head: &Vector{X: 4, Y: 0}, Trim: 1, Append: []Vector{{X: 3, Y: 0}}, Score: &score, Speed: &Vector{X: 1, Y: 0}},
+			{ID: "p2", Player: &player, Disconnected: &disconnected},
+			{ID: "p3", IsDead: true, Removed: true, Colours: &Colours{Head: "blue"}},
+		}},
+		&PongMessage{Event: "pong", Frame: frame, ClientTime: -1},
+		&ErrorMessage{Event: "error", Code: ErrBadMessage, Message: "Message is not valid JSON", RequestEvent: "move", RequestID: "r1"},
+		&RoomStateMessage{Event: "roomState", Frame: frame, RoomID: "room_1", State: RoomFinished, Previous: RoomPlaying},
+		&QueuedMessage{Event: "queued", Mode: "duel", Waiting: 1, Needed: 2},
+		&HostChangedMessage{Event: "hostChanged", Frame: frame, HostID: ""},
+		&CountdownMessage{Event: "countdown", Frame: frame, Seconds: 3},
+		&RoomListMessage{Event: "rooms", Rooms: []RoomSummary{summary, {ID: "room_2", State: RoomWaiting}}},
+		&RoomUpdateMessage{Event: "roomUpdate", Room: summary},
+		&RoomRemovedMessage{Event: "roomRemoved", ID: "room_1"},
+		&RematchStatusMessage{Event: "rematchStatus", Frame: frame, Votes: []string{"p1"}, Needed: 2},
+		&GameStartMessage{Event: "startGame", Frame: frame, Seed: math.MinInt64 + 1},
+		&GameOverMessage{Event: "gameover", Frame: frame, Results: GameResults{
+			Winner:       "p1",
+			WinCondition: WinLastStanding,
+			Ticks:        300,
+			Players: []PlayerResult{
+				{Rank: 1, ID: "p1", Name: "Ann", Score: 150, Length: 3, FoodEaten: map[string]int{"apple": 1}, Kills: 1, SurvivalTicks: 300, SurvivalTime: 30000},
+				{Rank: 2, ID: "p2", Name: "Bob", FoodEaten: map[string]int{}, Cause: DeathCollision, KilledBy: "p1", SurvivalTicks: 120, SurvivalTime: 12000},
+			},
+		}},
+		&SnakeDiedMessage{Event: "snakeDied", Frame: frame, ID: "p2", Cause: DeathHeadOn, Killer: "p1"},
+	}
+
+	for _, message := range messages {
+		t.Run(reflect.TypeOf(message).Elem().Name(), func(t *testing.T) {
+			data, err := MsgpackCodec.Marshal(message)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			decoded := reflect.New(reflect.TypeOf(message).Elem()).Interface()
+			if err := MsgpackCodec.Unmarshal(data, decoded); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, message) {
+				t.Errorf("round trip changed the message\n got %+v\nwant %+v", decoded, message)
+			}
+
+			// A message cut short anywhere is an error, never a panic
+			for i := range len(data) {
+				if err := MsgpackCodec.Unmarshal(data[:i], decoded); !errors.Is(err, errShortMsgpack) {
+					t.Fatalf("Unmarshal of the first %d of %d bytes: %v, want %v", i, len(data), err, errShortMsgpack)
+				}
+			}
+		})
+	}
+}
+
+func TestMsgpackToJSON(t *testing.T) {
+	move := []byte(`{"event":"move","key":"u","requestId":"r1"}`)
+
+	data, err := MsgpackCodec.Marshal(json.RawMessage(move))
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := MsgpackCodec.toJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(converted) != string(move) {
+		t.Errorf("toJSON = %s, want %s", converted, move)
+	}
+
+	if converted, _ := JSONCodec.toJSON(move); string(converted) != string(move) {
+		t.Errorf("JSON toJSON = %s, want it unchanged", converted)
+	}
+
+	if _, err := MsgpackCodec.toJSON(data[:len(data)-1]); !errors.Is(err, errShortMsgpack) {
+		t.Errorf("toJSON of a truncated frame: %v, want %v", err, errShortMsgpack)
+	}
+	if _, err := MsgpackCodec.toJSON(append(data, 0xc0)); err == nil {
+		t.Error("toJSON accepted trailing bytes")
+	}
+	if _, err := MsgpackCodec.toJSON([]byte{0xc1}); err == nil {
+		t.Error("toJSON accepted an unknown code")
+	}
+	if _, err := MsgpackCodec.toJSON([]byte{0x81, 0x01, 0x02}); err == nil {
+		t.Error("toJSON accepted a map with an integer key")
+	}
+}
+
+func TestMsgpackNumbers(t *testing.T) {
+	tests := []struct {
+		number string
+		code   byte
+		want   any
+	}{
+		{"0", 0x00, int64(0)},
+		{"127", 0x7f, int64(127)},
+		{"128", 0xd1, int64(128)},
+		{"40000", 0xd2, int64(40000)},
+		{"3000000000", 0xd3, int64(3000000000)},
+		{"-1", 0xff, int64(-1)},
+		{"-32", 0xe0, int64(-32)},
+		{"-33", 0xd0, int64(-33)},
+		{"-128", 0xd0, int64(-128)},
+		{"-129", 0xd1, int64(-129)},
+		{"-40000", 0xd2, int64(-40000)},
+		{"-3000000000", 0xd3, int64(-3000000000)},
+		{"-9223372036854775808", 0xd3, int64(math.MinInt64)},
+		{"1.5", 0xcb, 1.5},
+		{"-0.25", 0xcb, -0.25},
+		{"1e300", 0xcb, 1e300},
+	}
+
+	for _, tt := range tests {
+		data, err := appendMsgpack(nil, json.Number(tt.number))
+		if err != nil {
+			t.Errorf("%s: %v", tt.number, err)
+			continue
+		}
+		if data[0] != tt.code {
+			t.Errorf("%s encoded with code 0x%02x, want 0x%02x", tt.number, data[0], tt.code)
+		}
+		got, rest, err := readMsgpack(data, 0)
+		if err != nil || len(rest) > 0 || got != tt.want {
+			t.Errorf("%s decoded to %v (%T), %d bytes left, %v; want %v (%T)", tt.number, got, got, len(rest), err, tt.want, tt.want)
+		}
+	}
+
+	// Forms other encoders use for numbers
+	decodes := []struct {
+		data []byte
+		want any
+	}{
+		{[]byte{0xcc, 0xff}, uint64(255)},
+		{[]byte{0xcd, 0xff, 0xff}, uint64(65535)},
+		{[]byte{0xce, 0xff, 0xff, 0xff, 0xff}, uint64(math.MaxUint32)},
+		{[]byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(math.MaxUint64)},
+		{[]byte{0xca, 0xbf, 0xc0, 0x00, 0x00}, -1.5},
+	}
+	for _, tt := range decodes {
+		got, _, err := readMsgpack(tt.data, 0)
+		if err != nil || got != tt.want {
+			t.Errorf("% x decoded to %v (%T), %v; want %v (%T)", tt.data, got, got, err, tt.want, tt.want)
+		}
+		if _, _, err := readMsgpack(tt.data[:len(tt.data)-1], 0); !errors.Is(err, errShortMsgpack) {
+			t.Errorf("% x cut short: %v, want %v", tt.data, err, errShortMsgpack)
+		}
+	}
+}
+
+func TestMsgpackLengths(t *testing.T) {
+	array := func(n int) any {
+		items := make([]any, n)
+		for i := range items {
+			items[i] = true
+		}
+		return items
+	}
+	object := func(n int) any {
+		values := make(map[string]any, n)
+		for i := range n {
+			values[fmt.Sprint(i)] = nil
+		}
+		return values
+	}
+
+	tests := []struct {
+		name   string
+		value  any
+		header []byte
+	}{
+		{"fixstr", strings.Repeat("a", 31), []byte{0xbf}},
+		{"str8", strings.Repeat("a", 32), []byte{0xd9, 32}},
+		{"str16", strings.Repeat("a", 256), []byte{0xda, 0x01, 0x00}},
+		{"str32", strings.Repeat("a", 1<<16), []byte{0xdb, 0x00, 0x01, 0x00, 0x00}},
+		{"fixarray", array(15), []byte{0x9f}},
+		{"array16", array(16), []byte{0xdc, 0x00, 0x10}},
+		{"array32", array(1 << 16), []byte{0xdd, 0x00, 0x01, 0x00, 0x00}},
+		{"fixmap", object(15), []byte{0x8f}},
+		{"map16", object(16), []byte{0xde, 0x00, 0x10}},
+		{"map32", object(1 << 16), []byte{0xdf, 0x00, 0x01, 0x00, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := appendMsgpack(nil, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(data), string(tt.header)) {
+				t.Errorf("header % x, want % x", data[:len(tt.header)], tt.header)
+			}
+
+			got, rest, err := readMsgpack(data, 0)
+			if err != nil || len(rest) > 0 {
+				t.Fatalf("%d bytes left, %v", len(rest), err)
+			}
+			if !reflect.DeepEqual(got, tt.value) {
+				t.Error("round trip changed the value")
+			}
+
+			// Cut inside the header and just before the end
+			for _, n := range []int{len(tt.header) - 1, len(data) - 1} {
+				if _, _, err := readMsgpack(data[:n], 0); !errors.Is(err, errShortMsgpack) {
+					t.Errorf("first %d bytes: %v, want %v", n, err, errShortMsgpack)
+				}
+			}
+		})
+	}
+}
+
+func TestMsgpackDepth(t *testing.T) {
+	nested := func(code byte, depth int) []byte {
+		data := make([]byte, 0, 2*depth+1)
+		for range depth {
+			data = append(data, code)
+			if code == 0x81 {
+				data = append(data, 0xa1, 'k')
+			}
+		}
+		return append(data, 0xc0)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error // nil when it decodes
+	}{
+		{"arrays at the limit", nested(0x91, maxMsgpackDepth), nil},
+		{"maps at the limit", nested(0x81, maxMsgpackDepth), nil},
+		{"arrays too deep", nested(0x91, maxMsgpackDepth+1), errDeepMsgpack},
+		{"maps too deep", nested(0x81, maxMsgpackDepth+1), errDeepMsgpack},
+		{"8 MiB of arrays", nested(0x91, 8<<20), errDeepMsgpack},
+		{"array16 too deep", append(bytes.Repeat([]byte{0xdc, 0x00, 0x01}, maxMsgpackDepth+1), 0xc0), errDeepMsgpack},
+	}
+
+	for _, tt := range tests {
+		var decoded any
+		if err := MsgpackCodec.Unmarshal(tt.data, &decoded); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
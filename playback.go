@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Speeds a replay can be played at, as a multiple of the recorded FPS
+const (
+	minReplaySpeed = 0.25
+	maxReplaySpeed = 8.0
+)
+
+// playback plays a replay in a room of its own. The room runs the recorded
+// game again from its seed, feeding in the recorded events, and its
+// spectators get the same events as the spectators of the original game.
+type playback struct {
+	replay   *Replay
+	next     int // Index of the next event to apply
+	speed    float64
+	diverged bool // A checksum did not match, only logged once
+}
+
+func validReplaySpeed(speed float64) bool {
+	return speed >= minReplaySpeed && speed <= maxReplaySpeed
+}
+
+// playable reports whether the replay was recorded on the board this server
+// uses. The simulation reads the board size and food count from the live
+// config, so a replay from another board would not play the same game.
+func (replay *Replay) playable() bool {
+	return replay.Config.ScaleFactor == GameConfigJSON.ScaleFactor &&
+		replay.Config.FoodStorage == GameConfigJSON.FoodStorage
+}
+
+// startPlayback sets up a new room to play the replay. A replay room skips
+// the lobby and goes straight to playing; its clock starts with the first
+// spectator. Called before the room goroutine starts.
+func (r *Room) startPlayback(replay *Replay, speed float64) {
+	r.stopTimeout()
+	r.playback = &playback{replay: replay, speed: speed}
+	r.useSeed(replay.Seed)
+	for _, player := range replay.Players {
+		r.snakesMap[player.ID] = player
+	}
+	r.placeSnakes()
+	r.startStats()
+	r.state = RoomPlaying
+}
+
+// playbackInterval is the time between ticks at the playback speed.
+func (r *Room) playbackInterval() time.Duration {
+	fps := float64(max(r.playback.replay.Config.Fps, 1))
+	return time.Duration(float64(time.Second) / (fps * r.playback.speed))
+}
+
+// applyReplayEvents replays what the players did for the coming tick. It runs
+// before the tick starts, where the commands it stands in for ran.
+func (r *Room) applyReplayEvents() {
+	tick := r.tick + 1 - r.startTick
+	events := r.playback.replay.Events
+	for ; r.playback.next < len(events) && events[r.playback.next].Tick <= tick; r.playback.next++ {
+		event := events[r.playback.next]
+		player, exists := r.snakesMap[event.Player]
+		if !exists {
+			continue
+		}
+
+		switch event.Kind {
+		case ReplayTurn:
+			if event.Direction != nil {
+				r.inputs[event.Player] = []Vector{*event.Direction}
+			}
+		case ReplayFreeze, ReplayUnfreeze:
+			player.Disconnected = event.Kind == ReplayFreeze
+			r.snakesMap[event.Player] = player
+		case ReplayLeave:
+			if !player.Snake.IsDead {
+				r.recordDeath(event.Player, DeathLeft, "")
+			}
+			delete(r.inputs, event.Player)
+			delete(r.snakesMap, event.Player)
+		}
+	}
+}
+
+// verify compares the state after a tick with the recorded checksum.
+func (p *playback) verify(r *Room, tick uint64) {
+	if p.diverged || tick%replayChecksumInterval != 0 {
+		return
+	}
+	for _, checksum := range p.replay.Checksums {
+		if checksum.Tick == tick && checksum.Sum != r.checksum() {
+			log.Printf("Replay %s diverged from the recording at tick %d", p.replay.ID, tick)
+			p.diverged = true
+		}
+	}
+}
+
+// playbackOver reports whether the replay room has played every recorded tick.
+func (r *Room) playbackOver() bool {
+	return r.playback != nil && r.tick-r.startTick >= r.playback.replay.Ticks
+}
+
+// setPlaybackSpeed changes how fast a replay room plays.
+func (r *Room) setPlaybackSpeed(speed float64) error {
+	var err error
+	ok := r.do(func() {
+		if r.playback == nil {
+			err = eventError(ErrNotAllowed, "Room %s is not playing a replay", r.id)
+			return
+		}
+		r.playback.speed = speed
+		if r.ticker != nil {
+			r.ticker.Reset(r.playbackInterval())
+		}
+		log.Printf("Replay room %s playing at %gx", r.id, speed)
+	})
+	if !ok {
+		return eventError(ErrRoomNotFound, "Room %s not found", r.id)
+	}
+	return err
+}
+
+// watchReplay plays a stored replay to the spectating client in a new room.
+func watchReplay(client *Client, replay *Replay, speed float64) bool {
+	room := roomManager.CreateReplay(replay, speed)
+	log.Printf("Playing replay %s in room %s at %gx", replay.ID, room.id, speed)
+	return spectateRoom(client, room)
+}
+
+var errReplayBoard = errors.New("replay was recorded on a different board")
+
+// openReplay loads a replay that can be played on this server.
+func openReplay(id string) (*Replay, error) {
+	replay, err := loadReplay(id)
+	if err != nil {
+		return nil, err
+	}
+	if !replay.playable() {
+		return nil, errReplayBoard
+	}
+	return replay, nil
+}
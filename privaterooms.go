@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"log"
+)
+
+// Join codes leave out characters that are easy to mix up, like 0 and O.
+const joinCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+const joinCodeLength = 6
+
+func newJoinCode() string {
+	b := make([]byte, joinCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = joinCodeAlphabet[int(b[i])%len(joinCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// findPrivateRoom looks up a private room by join code and checks the password.
+func findPrivateRoom(code string, password string) (*Room, error) {
+	room, exists := roomManager.ByCode(code)
+	if !exists {
+		return nil, eventError(ErrRoomNotFound, "No room with code %s", code)
+	}
+	if subtle.ConstantTimeCompare([]byte(room.password), []byte(password)) != 1 {
+		return nil, eventError(ErrWrongPassword, "Wrong password for room %s", code)
+	}
+	return room, nil
+}
+
+// switchRoom moves a connected player out of their current room, if any, and
+// into room, which the client has already joined. The player gets a new
+// session for the room and has to send newPlayer again.
+func switchRoom(client *Client, previous *Room, room *Room) {
+	if previous != nil && previous != room {
+		previous.leave(client)
+		previous.removePlayer(client.playerId)
+	}
+
+	client.roomId = room.id
+	startSession(client, room)
+	log.Printf("Player %s moved to room %s", client.playerId, room.id)
+}
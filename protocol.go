@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// Codec is a wire format a client can negotiate through the
+// Sec-WebSocket-Protocol header. Clients that do not ask for one get JSON.
+type Codec struct {
+	Name      string
+	FrameType int
+	Marshal   func(v any) ([]byte, error)
+	Unmarshal func(data []byte, v any) error
+}
+
+var JSONCodec = &Codec{
+	Name:      "snake.json",
+	FrameType: websocket.TextMessage,
+	Marshal:   json.Marshal,
+	Unmarshal: json.Unmarshal,
+}
+
+var MsgpackCodec = &Codec{
+	Name:      "snake.msgpack",
+	FrameType: websocket.BinaryMessage,
+	Marshal:   marshalMsgpack,
+	Unmarshal: unmarshalMsgpack,
+}
+
+// Ordered by server preference when a client offers several.
+var codecs = []*Codec{MsgpackCodec, JSONCodec}
+
+func subprotocols() []string {
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		names[i] = codec.Name
+	}
+	return names
+}
+
+func codecFor(subprotocol string) *Codec {
+	for _, codec := range codecs {
+		if codec.Name == subprotocol {
+			return codec
+		}
+	}
+	return JSONCodec
+}
+
+// toJSON converts an incoming frame in this codec to the JSON the message
+// handlers parse.
+func (c *Codec) toJSON(data []byte) ([]byte, error) {
+	if c == JSONCodec {
+		return data, nil
+	}
+	var decoded any
+	if err := c.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
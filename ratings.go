@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Ratings are the players' matchmaking skill, an Elo rating updated after
+// every finished game. They belong to the player ID in the token, so they last
+// as long as the token, and are kept in memory only.
+type Ratings struct {
+	mutex   sync.Mutex
+	ratings map[string]rating
+}
+
+type rating struct {
+	value   int
+	updated time.Time
+}
+
+// Rating of a player who has not finished a game yet
+const initialRating = 1000
+
+// How far one game can move a rating
+const ratingK = 32
+
+var ratings = &Ratings{ratings: make(map[string]rating)}
+
+// Get returns the player's rating.
+func (rt *Ratings) Get(playerId string) int {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	return rt.rating(playerId)
+}
+
+func (rt *Ratings) rating(playerId string) int {
+	if rating, exists := rt.ratings[playerId]; exists {
+		return rating.value
+	}
+	return initialRating
+}
+
+// Update rates the players of a finished game against each other by their
+// rank. Games with a single player or that everyone left are not rated.
+func (rt *Ratings) Update(results GameResults) {
+	players := results.Players
+	if len(players) < 2 || results.WinCondition == WinAbandoned {
+		return
+	}
+
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	now := time.Now()
+	rt.expire(now)
+	before := make([]float64, len(players))
+	for i, player := range players {
+		before[i] = float64(rt.rating(player.ID))
+	}
+	for i, player := range players {
+		change := 0.0
+		for j, other := range players {
+			if i == j {
+				continue
+			}
+			score := 0.5
+			if player.Rank < other.Rank {
+				score = 1
+			} else if player.Rank > other.Rank {
+				score = 0
+			}
+			expected := 1 / (1 + math.Pow(10, (before[j]-before[i])/400))
+			change += ratingK * (score - expected)
+		}
+		rt.ratings[player.ID] = rating{
+			value:   int(math.Round(before[i] + change/float64(len(players)-1))),
+			updated: now,
+		}
+	}
+}
+
+// expire forgets the ratings of players whose token has run out since their
+// last game; nobody can play under that ID again.
+func (rt *Ratings) expire(now time.Time) {
+	for playerId, rating := range rt.ratings {
+		if now.Sub(rating.updated) > Settings.TokenTTL {
+			delete(rt.ratings, playerId)
+		}
+	}
+}
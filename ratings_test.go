@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRatings(t *testing.T) {
+	rt := &Ratings{ratings: make(map[string]rating)}
+	duel := GameResults{
+		WinCondition: WinLastStanding,
+		Players:      []PlayerResult{{ID: "a", Rank: 1}, {ID: "b", Rank: 2}},
+	}
+
+	rt.Update(duel)
+	if a, b := rt.Get("a"), rt.Get("b"); a != initialRating+ratingK/2 || b != initialRating-ratingK/2 {
+		t.Errorf("ratings %d and %d after an even duel", a, b)
+	}
+
+	rt.Update(GameResults{WinCondition: WinAbandoned, Players: duel.Players})
+	if a := rt.Get("a"); a != initialRating+ratingK/2 {
+		t.Errorf("abandoned game moved the rating to %d", a)
+	}
+
+	// Ratings outlive their token by no more than TokenTTL
+	stale := rt.ratings["a"]
+	stale.updated = time.Now().Add(-Settings.TokenTTL - time.Minute)
+	rt.ratings["a"] = stale
+	rt.Update(GameResults{WinCondition: WinLastStanding, Players: []PlayerResult{{ID: "b", Rank: 1}, {ID: "c", Rank: 2}}})
+	if _, exists := rt.ratings["a"]; exists {
+		t.Error("rating of an expired token was kept")
+	}
+	if b := rt.Get("b"); b <= initialRating-ratingK/2 {
+		t.Errorf("rating %d did not go up after a win", b)
+	}
+}
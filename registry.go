@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// EventContext is what a handler gets for one incoming client event.
+type EventContext struct {
+	Client    *Client
+	Room      *Room // The client's room, nil if it no longer exists
+	Event     string
+	RequestID string
+	Payload   []byte // The raw JSON message, decoded by the handler
+}
+
+// EventError is returned by handlers to report a failure to the client as an
+// error event with the given code.
+type EventError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *EventError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+func eventError(code ErrorCode, format string, args ...any) *EventError {
+	return &EventError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type HandlerFunc func(ctx *EventContext) error
+
+// Middleware wraps a handler, for example to check permissions before it runs.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Validator is implemented by payloads that check their own fields.
+type Validator interface {
+	Validate() error
+}
+
+// EventRegistry maps event names to their handlers.
+type EventRegistry struct {
+	handlers   map[string]HandlerFunc
+	middleware []Middleware
+}
+
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{handlers: make(map[string]HandlerFunc)}
+}
+
+// Use adds middleware that runs for every event, outside any per-handler
+// middleware. It must be called before the handlers it should wrap are added.
+func (r *EventRegistry) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Handle registers the handler for an event. Middleware is applied in the
+// order given, so the first one runs first.
+func (r *EventRegistry) Handle(event string, handler HandlerFunc, middleware ...Middleware) {
+	chain := append(append([]Middleware{}, r.middleware...), middleware...)
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+	r.handlers[event] = handler
+}
+
+// Dispatch runs the handler for ctx.Event and sends any error it returns back
+// to the client.
+func (r *EventRegistry) Dispatch(ctx *EventContext) {
+	handler, exists := r.handlers[ctx.Event]
+	if !exists {
+		ctx.Client.sendError(ErrUnknownEvent, "Unknown event "+ctx.Event, ctx.Event, ctx.RequestID)
+		return
+	}
+
+	err := handler(ctx)
+	if err == nil {
+		return
+	}
+
+	var eventErr *EventError
+	if errors.As(err, &eventErr) {
+		ctx.Client.sendError(eventErr.Code, eventErr.Message, ctx.Event, ctx.RequestID)
+		return
+	}
+	log.Printf("Handler for %s failed: %v", ctx.Event, err)
+	ctx.Client.sendError(ErrInternal, "Something went wrong", ctx.Event, ctx.RequestID)
+}
+
+// Typed decodes the message into T and validates it before calling handle.
+func Typed[T any](handle func(ctx *EventContext, payload T) error) HandlerFunc {
+	return func(ctx *EventContext) error {
+		var payload T
+		if err := json.Unmarshal(ctx.Payload, &payload); err != nil {
+			return eventError(ErrBadMessage, "Invalid %s payload", ctx.Event)
+		}
+		if validator, ok := any(&payload).(Validator); ok {
+			if err := validator.Validate(); err != nil {
+				return err
+			}
+		}
+		return handle(ctx, payload)
+	}
+}
+
+// LogEvents logs each event as it arrives.
+func LogEvents(next HandlerFunc) HandlerFunc {
+	return func(ctx *EventContext) error {
+		log.Printf("Event %s from player %s", ctx.Event, ctx.Client.playerId)
+		return next(ctx)
+	}
+}
+
+// RateLimit allows each client perSecond events of a kind on average, with
+// bursts of up to burst events.
+func RateLimit(perSecond float64, burst int) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *EventContext) error {
+			if !ctx.Client.allow(ctx.Event, perSecond, burst) {
+				return eventError(ErrRateLimited, "Too many %s events", ctx.Event)
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// RequireRoom rejects events from clients whose room is gone.
+func RequireRoom(next HandlerFunc) HandlerFunc {
+	return func(ctx *EventContext) error {
+		if ctx.Room == nil {
+			return eventError(ErrRoomNotFound, "Room %s not found", ctx.Client.roomId)
+		}
+		return next(ctx)
+	}
+}
+
+// PlayersOnly rejects events from spectators.
+func PlayersOnly(next HandlerFunc) HandlerFunc {
+	return func(ctx *EventContext) error {
+		if ctx.Client.spectating {
+			return eventError(ErrNotAllowed, "Spectators cannot send %s", ctx.Event)
+		}
+		return next(ctx)
+	}
+}
+
+// SpectatorsOnly rejects events from players.
+func SpectatorsOnly(next HandlerFunc) HandlerFunc {
+	return func(ctx *EventContext) error {
+		if !ctx.Client.spectating {
+			return eventError(ErrNotAllowed, "Only spectators can send %s", ctx.Event)
+		}
+		return next(ctx)
+	}
+}
+
+// HostOnly rejects events from players who are not the room's host. It must
+// run after RequireRoom.
+func HostOnly(next HandlerFunc) HandlerFunc {
+	return func(ctx *EventContext) error {
+		if !ctx.Room.isHost(ctx.Client.playerId) {
+			return eventError(ErrNotHost, "Only the host can send %s", ctx.Event)
+		}
+		return next(ctx)
+	}
+}
+
+// BeforeGameStart rejects events once the room's game is running.
+func BeforeGameStart(next HandlerFunc) HandlerFunc {
+	return func(ctx *EventContext) error {
+		if ctx.Room.isGameStarted() {
+			return eventError(ErrGameStarted, "The game has already started")
+		}
+		return next(ctx)
+	}
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the client's bucket for key. Only the reader
+// goroutine dispatches events, so the buckets need no locking.
+func (c *Client) allow(key string, perSecond float64, burst int) bool {
+	if c.rateLimits == nil {
+		c.rateLimits = make(map[string]*tokenBucket)
+	}
+
+	now := time.Now()
+	bucket, exists := c.rateLimits[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(burst), last: now}
+		c.rateLimits[key] = bucket
+	}
+
+	bucket.tokens = min(float64(burst), bucket.tokens+now.Sub(bucket.last).Seconds()*perSecond)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
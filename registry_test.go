@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestClient is a client without a connection. What it is sent stays in
+// its queue, see sentErrors.
+func newTestClient(playerId string) *Client {
+	return &Client{
+		playerId: playerId,
+		identity: Identity{PlayerID: playerId, Name: playerId},
+		codec:    JSONCodec,
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// sentErrors takes the error events queued for the client.
+func sentErrors(t *testing.T, c *Client) []ErrorMessage {
+	t.Helper()
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+
+	var sent []ErrorMessage
+	for _, frame := range c.queue {
+		if frame.event != "error" {
+			continue
+		}
+		var message ErrorMessage
+		if err := json.Unmarshal(frame.data, &message); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, message)
+	}
+	c.queue = nil
+	return sent
+}
+
+// dispatch sends one event through the registry and returns the error code
+// the client got back, if any.
+func dispatch(t *testing.T, registry *EventRegistry, ctx *EventContext) ErrorCode {
+	t.Helper()
+	registry.Dispatch(ctx)
+	sent := sentErrors(t, ctx.Client)
+	switch len(sent) {
+	case 0:
+		return ""
+	case 1:
+		if sent[0].RequestEvent != ctx.Event || sent[0].RequestID != ctx.RequestID {
+			t.Errorf("error for %q/%q, want %q/%q", sent[0].RequestEvent, sent[0].RequestID, ctx.Event, ctx.RequestID)
+		}
+		return sent[0].Code
+	}
+	t.Fatalf("%d errors for one event", len(sent))
+	return ""
+}
+
+func TestDispatchErrors(t *testing.T) {
+	registry := NewEventRegistry()
+	registry.Handle("move", Typed(func(ctx *EventContext, payload MovePayload) error { return nil }))
+	registry.Handle("eventError", func(ctx *EventContext) error {
+		return eventError(ErrRoomFull, "full")
+	})
+	registry.Handle("wrapped", func(ctx *EventContext) error {
+		return fmt.Errorf("joining: %w", eventError(ErrWrongPassword, "wrong"))
+	})
+	registry.Handle("plainError", func(ctx *EventContext) error {
+		return errors.New("database is down")
+	})
+
+	tests := []struct {
+		event   string
+		payload string
+		want    ErrorCode
+	}{
+		{"move", `{"event":"move","key":"u"}`, ""},
+		{"move", `{"event":"move","key":5}`, ErrBadMessage},
+		{"move", `not json`, ErrBadMessage},
+		{"move", `{"event":"move","key":"x"}`, ErrInvalidDirection},
+		{"move", `{"event":"move"}`, ErrInvalidDirection},
+		{"jump", `{"event":"jump"}`, ErrUnknownEvent},
+		{"", `{}`, ErrUnknownEvent},
+		{"eventError", `{}`, ErrRoomFull},
+		{"wrapped", `{}`, ErrWrongPassword},
+		{"plainError", `{}`, ErrInternal},
+	}
+
+	client := newTestClient("p1")
+	for _, tt := range tests {
+		ctx := &EventContext{Client: client, Event: tt.event, RequestID: "r1", Payload: []byte(tt.payload)}
+		if got := dispatch(t, registry, ctx); got != tt.want {
+			t.Errorf("%s %s: error %q, want %q", tt.event, tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx *EventContext) error {
+				calls = append(calls, name)
+				return next(ctx)
+			}
+		}
+	}
+	stop := func(next HandlerFunc) HandlerFunc {
+		return func(ctx *EventContext) error {
+			calls = append(calls, "stop")
+			return eventError(ErrNotAllowed, "stopped")
+		}
+	}
+	handler := func(ctx *EventContext) error {
+		calls = append(calls, "handler")
+		return nil
+	}
+
+	registry := NewEventRegistry()
+	registry.Use(record("global1"), record("global2"))
+	registry.Handle("ordered", handler, record("first"), record("second"))
+	registry.Handle("stopped", handler, record("first"), stop, record("second"))
+	registry.Use(record("late"))
+	registry.Handle("afterUse", handler)
+
+	tests := []struct {
+		event string
+		calls []string
+		want  ErrorCode
+	}{
+		{"ordered", []string{"global1", "global2", "first", "second", "handler"}, ""},
+		{"stopped", []string{"global1", "global2", "first", "stop"}, ErrNotAllowed},
+		{"afterUse", []string{"global1", "global2", "late", "handler"}, ""},
+	}
+
+	client := newTestClient("p1")
+	for _, tt := range tests {
+		calls = nil
+		got := dispatch(t, registry, &EventContext{Client: client, Event: tt.event})
+		if got != tt.want || !slices.Equal(calls, tt.calls) {
+			t.Errorf("%s: ran %v with error %q, want %v with %q", tt.event, calls, got, tt.calls, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	registry := NewEventRegistry()
+	registry.Use(RateLimit(2, 3))
+	registry.Handle("move", func(ctx *EventContext) error { return nil })
+	registry.Handle("ping", func(ctx *EventContext) error { return nil })
+
+	client := newTestClient("p1")
+	send := func(event string) ErrorCode {
+		return dispatch(t, registry, &EventContext{Client: client, Event: event})
+	}
+	// Moves the bucket's clock back, as if d had passed
+	wait := func(event string, d time.Duration) {
+		client.rateLimits[event].last = client.rateLimits[event].last.Add(-d)
+	}
+	allowed := func(event string, n int) {
+		t.Helper()
+		for i := range n {
+			if got := send(event); got != "" {
+				t.Fatalf("%s %d of %d: %q", event, i+1, n, got)
+			}
+		}
+		if got := send(event); got != ErrRateLimited {
+			t.Fatalf("%s %d: %q, want %q", event, n+1, got, ErrRateLimited)
+		}
+	}
+
+	// A full bucket allows a burst
+	allowed("move", 3)
+	// Each event kind has its own bucket
+	allowed("ping", 3)
+	// It refills at perSecond
+	wait("move", time.Second)
+	allowed("move", 2)
+	wait("move", 750*time.Millisecond)
+	allowed("move", 1)
+	// But never beyond burst
+	wait("move", time.Hour)
+	allowed("move", 3)
+
+	// Another client has its own buckets
+	client = newTestClient("p2")
+	allowed("move", 3)
+}
+
+func TestRoomMiddleware(t *testing.T) {
+	host := newTestClient("host")
+	guest := newTestClient("guest")
+	room := NewRoomManager().Create(gameModes["party"], host, guest)
+	defer room.do(func() { room.setState(RoomClosed) })
+	sentErrors(t, host)
+	sentErrors(t, guest)
+
+	registry := NewEventRegistry()
+	handler := func(ctx *EventContext) error { return nil }
+	registry.Handle("hostOnly", handler, RequireRoom, HostOnly)
+	registry.Handle("beforeStart", handler, RequireRoom, BeforeGameStart)
+	registry.Handle("players", handler, PlayersOnly)
+	registry.Handle("spectators", handler, SpectatorsOnly)
+
+	spectator := newTestClient("spectator")
+	spectator.spectating = true
+
+	tests := []struct {
+		name   string
+		client *Client
+		room   *Room
+		event  string
+		state  RoomState
+		want   ErrorCode
+	}{
+		{"host", host, room, "hostOnly", RoomWaiting, ""},
+		{"not the host", guest, room, "hostOnly", RoomWaiting, ErrNotHost},
+		{"no room", host, nil, "hostOnly", RoomWaiting, ErrRoomNotFound},
+		{"waiting", guest, room, "beforeStart", RoomWaiting, ""},
+		{"countdown", guest, room, "beforeStart", RoomCountdown, ErrGameStarted},
+		{"playing", host, room, "beforeStart", RoomPlaying, ErrGameStarted},
+		{"finished", host, room, "beforeStart", RoomFinished, ErrGameStarted},
+		{"player", guest, room, "players", RoomWaiting, ""},
+		{"spectator as player", spectator, room, "players", RoomWaiting, ErrNotAllowed},
+		{"spectator", spectator, room, "spectators", RoomWaiting, ""},
+		{"player as spectator", guest, room, "spectators", RoomWaiting, ErrNotAllowed},
+	}
+
+	for _, tt := range tests {
+		// Set directly, the entry actions would start timers and the game
+		room.do(func() { room.state = tt.state })
+		ctx := &EventContext{Client: tt.client, Room: tt.room, Event: tt.event}
+		if got := dispatch(t, registry, ctx); got != tt.want {
+			t.Errorf("%s: error %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	room.do(func() { room.state = RoomWaiting })
+}
+
+// TestNewPlayerPayload checks that a client only chooses its name and colours
+// when it enters the waiting room.
+func TestNewPlayerPayload(t *testing.T) {
+	client := newTestClient("p1")
+	client.identity.Guest = true
+	room := NewRoomManager().Create(gameModes["party"], client)
+	defer room.do(func() { room.setState(RoomClosed) })
+
+	payload := `{"event":"newPlayer","player":{"id":"p2","name":"Bob","colours":{"body":"#fff"},"type":"server","ready":true,"disconnected":true,"snake":{"x":5,"score":99999,"type":"server","isDead":true,"size":40}}}`
+	ctx := &EventContext{Client: client, Room: room, Event: "newPlayer", Payload: []byte(payload)}
+	if got := dispatch(t, newEvents(), ctx); got != "" {
+		t.Fatalf("error %q", got)
+	}
+
+	var player Player
+	var exists bool
+	room.do(func() { player, exists = room.waitingRoom["p1"] })
+	if !exists {
+		t.Fatal("player is not in the waiting room")
+	}
+	if player.Name != "Bob" || player.Colours.Body != "#fff" {
+		t.Errorf("name %q and colours %+v, want Bob and the client's colours", player.Name, player.Colours)
+	}
+	if player.Type != "player" || player.Ready || player.Disconnected {
+		t.Errorf("player %+v took fields from the payload", player)
+	}
+	want := Snake{Speed: Vector{X: 1, Y: 0}, Tail: []Vector{}}
+	if !reflect.DeepEqual(player.Snake, want) {
+		t.Errorf("snake %+v, want %+v", player.Snake, want)
+	}
+}
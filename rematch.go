@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"slices"
+)
+
+// After a game the room stays in the finished state with its players. Once
+// every connected player who took part votes for a rematch, the room resets
+// and goes back to the waiting room.
+
+// voteRematch records a player's vote for a rematch.
+func (r *Room) voteRematch(playerId string) error {
+	var err error
+	ok := r.do(func() {
+		if r.state != RoomFinished {
+			err = eventError(ErrGameNotFinished, "The game has not finished")
+			return
+		}
+		if _, played := r.snakesMap[playerId]; !played {
+			err = eventError(ErrNotAllowed, "Only players from the last game can vote")
+			return
+		}
+		r.rematchVotes[playerId] = true
+		r.checkRematch()
+	})
+	if !ok {
+		return eventError(ErrRoomNotFound, "Room %s not found", r.id)
+	}
+	return err
+}
+
+// rematchVoters are the connected players who took part in the last game.
+func (r *Room) rematchVoters() []string {
+	voters := []string{}
+	for playerId := range r.snakesMap {
+		if r.connected(playerId) {
+			voters = append(voters, playerId)
+		}
+	}
+	slices.Sort(voters)
+	return voters
+}
+
+// checkRematch tells the room how the vote stands and resets the room once
+// every voter agreed.
+func (r *Room) checkRematch() {
+	if r.state != RoomFinished {
+		return
+	}
+
+	voters := r.rematchVoters()
+	votes := []string{}
+	for _, playerId := range voters {
+		if r.rematchVotes[playerId] {
+			votes = append(votes, playerId)
+		}
+	}
+	r.broadcast(&RematchStatusMessage{
+		Event:  "rematchStatus",
+		Votes:  votes,
+		Needed: len(voters),
+	})
+
+	if len(votes) > 0 && len(votes) == len(voters) {
+		log.Printf("Rematch agreed in room %s", r.id)
+		r.setState(RoomWaiting)
+	}
+}
+
+// resetForRematch puts the connected players of the last game back in the
+// waiting room with fresh snakes and reseeds the room, which lays out new
+// food. Runs on entry to the waiting state from finished.
+func (r *Room) resetForRematch() {
+	players := r.snakesMap
+	r.snakesMap = make(map[string]Player)
+	r.lastSnakes = nil
+	r.inputs = make(map[string][]Vector)
+	r.rematchVotes = make(map[string]bool)
+	r.waitingRoom = make(map[string]Player)
+	r.reseed()
+
+	for _, client := range r.players {
+		player, played := players[client.playerId]
+		if !played {
+			continue
+		}
+		player.Snake = Snake{
+			Speed: Vector{X: 1, Y: 0},
+			Tail:  []Vector{},
+			Type:  player.Snake.Type,
+		}
+		player.Ready = false
+		player.Disconnected = false
+		r.addToWaitingRoom(player)
+	}
+
+	for _, client := range r.recipients() {
+		client.needsKeyframe.Store(true)
+		r.sendConfig(client)
+	}
+	r.broadcastWaitingRoomStatus()
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Replay is a recorded game: everything needed to play it again from its seed.
+// Replays are stored gzipped JSON in Settings.ReplayDir.
+type Replay struct {
+	ID        string           `json:"id"`
+	RoomID    string           `json:"roomId"`
+	Mode      GameMode         `json:"mode"`
+	Config    Config           `json:"config"`
+	Seed      int64            `json:"seed"`
+	StartedAt int64            `json:"startedAt"` // Unix milliseconds
+	Players   []Player         `json:"players"`   // As the game started, in ID order
+	Events    []ReplayEvent    `json:"events"`
+	Checksums []ReplayChecksum `json:"checksums"`
+	Ticks     uint64           `json:"ticks"`
+	Results   GameResults      `json:"results"`
+}
+
+// ReplayEventKind is something a player did that changed the game.
+type ReplayEventKind string
+
+const (
+	ReplayTurn     ReplayEventKind = "turn"     // The snake changed direction
+	ReplayFreeze   ReplayEventKind = "freeze"   // The player dropped and the snake is held
+	ReplayUnfreeze ReplayEventKind = "unfreeze" // The player reconnected
+	ReplayLeave    ReplayEventKind = "leave"    // The player left the game
+)
+
+// ReplayEvent happens on a tick of the game, counted from 1 for its first tick.
+type ReplayEvent struct {
+	Tick      uint64          `json:"t"`
+	Player    string          `json:"p"`
+	Kind      ReplayEventKind `json:"k"`
+	Direction *Vector         `json:"d,omitempty"` // Set for turns
+}
+
+// ReplayChecksum is a hash of the game state after a tick, see Room.checksum.
+type ReplayChecksum struct {
+	Tick uint64 `json:"t"`
+	Sum  uint32 `json:"s"`
+}
+
+// ReplayInfo is what the replay list shows about a replay.
+type ReplayInfo struct {
+	ID         string `json:"id"`
+	Size       int64  `json:"size"`       // Bytes
+	RecordedAt int64  `json:"recordedAt"` // Unix milliseconds
+}
+
+// Ticks between checksums in a replay
+const replayChecksumInterval = 50
+
+const replaySuffix = ".replay.gz"
+
+var replayIdPattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// startRecording begins recording the game that is starting, if replays are
+// enabled. Rooms that play a replay are never recorded.
+func (r *Room) startRecording() {
+	if Settings.ReplayDir == "" || r.playback != nil {
+		return
+	}
+
+	startedAt := time.Now().UnixMilli()
+	players := make([]Player, 0, len(r.snakesMap))
+	for _, id := range slices.Sorted(maps.Keys(r.snakesMap)) {
+		player := r.snakesMap[id]
+		player.Snake.Tail = slices.Clone(player.Snake.Tail)
+		players = append(players, player)
+	}
+
+	r.recording = &Replay{
+		ID:        fmt.Sprintf("%s-%d", r.id, startedAt),
+		RoomID:    r.id,
+		Mode:      *r.mode,
+		Config:    GameConfigJSON,
+		Seed:      r.seed,
+		StartedAt: startedAt,
+		Players:   players,
+	}
+}
+
+// recordEvent adds an event to the recording. tick is the room tick the event
+// takes effect on: the current one during tickGame, the next one for commands
+// that run between ticks.
+func (r *Room) recordEvent(tick uint64, playerId string, kind ReplayEventKind, direction *Vector) {
+	if r.recording == nil {
+		return
+	}
+	r.recording.Events = append(r.recording.Events, ReplayEvent{
+		Tick:      tick - r.startTick,
+		Player:    playerId,
+		Kind:      kind,
+		Direction: direction,
+	})
+}
+
+// checkTick records a checksum every replayChecksumInterval ticks, or checks
+// it when playing a replay.
+func (r *Room) checkTick() {
+	tick := r.tick - r.startTick
+	switch {
+	case r.recording != nil && tick%replayChecksumInterval == 0:
+		r.recording.Checksums = append(r.recording.Checksums, ReplayChecksum{Tick: tick, Sum: r.checksum()})
+	case r.playback != nil:
+		r.playback.verify(r, tick)
+	}
+}
+
+// checksum hashes the snakes and the food, so a replay can tell when it no
+// longer matches the game it was recorded from.
+func (r *Room) checksum() uint32 {
+	h := fnv.New32a()
+	for _, id := range slices.Sorted(maps.Keys(r.snakesMap)) {
+		s := r.snakesMap[id].Snake
+		fmt.Fprintf(h, "%s %d %d %d %d %t %v;", id, s.X, s.Y, s.Size, s.Score, s.IsDead, s.Tail)
+	}
+	fmt.Fprint(h, r.FoodCoordinates)
+	return h.Sum32()
+}
+
+// saveRecording finishes the recording of the game that just ended and
+// writes it to disk in the background.
+func (r *Room) saveRecording() {
+	if r.recording == nil {
+		return
+	}
+	replay := r.recording
+	r.recording = nil
+
+	replay.Ticks = r.tick - r.startTick
+	replay.Results = r.gameResults()
+	go writeReplay(replay)
+}
+
+// writeReplay stores the replay and drops the oldest ones beyond
+// Settings.MaxReplays.
+func writeReplay(replay *Replay) {
+	if err := os.MkdirAll(Settings.ReplayDir, 0o755); err != nil {
+		log.Printf("Error creating replay directory: %v", err)
+		return
+	}
+
+	// Written under a temporary name first, so a half written file is never listed
+	file, err := os.CreateTemp(Settings.ReplayDir, ".replay-*")
+	if err != nil {
+		log.Printf("Error saving replay %s: %v", replay.ID, err)
+		return
+	}
+	gz := gzip.NewWriter(file)
+	err = json.NewEncoder(gz).Encode(replay)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), replayPath(replay.ID))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		log.Printf("Error saving replay %s: %v", replay.ID, err)
+		return
+	}
+
+	log.Printf("Saved replay %s (%d ticks, %d events)", replay.ID, replay.Ticks, len(replay.Events))
+	pruneReplays()
+}
+
+func replayPath(id string) string {
+	return filepath.Join(Settings.ReplayDir, id+replaySuffix)
+}
+
+// listReplays returns the stored replays, newest first.
+func listReplays() []ReplayInfo {
+	entries, err := os.ReadDir(Settings.ReplayDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error listing replays: %v", err)
+		}
+		return []ReplayInfo{}
+	}
+
+	replays := []ReplayInfo{}
+	for _, entry := range entries {
+		id, isReplay := strings.CutSuffix(entry.Name(), replaySuffix)
+		info, err := entry.Info()
+		if !isReplay || err != nil {
+			continue
+		}
+		replays = append(replays, ReplayInfo{
+			ID:         id,
+			Size:       info.Size(),
+			RecordedAt: info.ModTime().UnixMilli(),
+		})
+	}
+	slices.SortFunc(replays, func(a, b ReplayInfo) int {
+		return cmp.Compare(b.RecordedAt, a.RecordedAt)
+	})
+	return replays
+}
+
+func pruneReplays() {
+	replays := listReplays()
+	if len(replays) <= Settings.MaxReplays {
+		return
+	}
+	for _, replay := range replays[Settings.MaxReplays:] {
+		if err := os.Remove(replayPath(replay.ID)); err != nil {
+			log.Printf("Error removing replay %s: %v", replay.ID, err)
+		}
+	}
+}
+
+// loadReplay reads a stored replay.
+func loadReplay(id string) (*Replay, error) {
+	if !replayIdPattern.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+
+	file, err := os.Open(replayPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	var replay Replay
+	if err := json.NewDecoder(gz).Decode(&replay); err != nil {
+		return nil, err
+	}
+	return &replay, nil
+}
+
+// replaysHandler lists the stored replays.
+func replaysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listReplays())
+}
+
+// replayHandler downloads one replay, /replays/<id>.
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/replays/")
+	if !replayIdPattern.MatchString(id) {
+		http.Error(w, "Replay not found", http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(replayPath(id))
+	if err != nil {
+		http.Error(w, "Replay not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Replay not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+replaySuffix))
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
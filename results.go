@@ -0,0 +1,189 @@
+package main
+
+import (
+	"cmp"
+	"go/ws/game"
+	"slices"
+)
+
+// DeathCause says how a snake's game ended.
+type DeathCause = game.DeathCause
+
+const (
+	DeathSelf      = game.DeathSelf
+	DeathCollision = game.DeathCollision
+	DeathHeadOn    = game.DeathHeadOn
+	DeathWall      = game.DeathWall
+	DeathLeft      = game.DeathLeft
+)
+
+// WinCondition says what decided the match.
+type WinCondition string
+
+const (
+	WinSolo         WinCondition = "solo"          // Only one player took part
+	WinLastStanding WinCondition = "last_standing" // One snake outlived all others
+	WinScore        WinCondition = "score"         // The last snakes died together, the best score won
+	WinDraw         WinCondition = "draw"          // The last snakes died together with the same score
+	WinAbandoned    WinCondition = "abandoned"     // Every player left
+)
+
+// PlayerStats is what a room tracks about each player during a game.
+type PlayerStats struct {
+	ID        string
+	Name      string
+	FoodEaten map[string]int
+	Kills     int
+	Cause     DeathCause
+	KilledBy  string
+	Dead      bool
+	DiedAt    uint64 // Tick the snake died on, set with Dead
+	Score     int
+	Length    int
+}
+
+type PlayerResult struct {
+	Rank          int            `json:"rank"`
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Score         int            `json:"score"`
+	Length        int            `json:"length"`
+	FoodEaten     map[string]int `json:"foodEaten"`
+	Kills         int            `json:"kills"`
+	Cause         DeathCause     `json:"cause,omitempty"`
+	KilledBy      string         `json:"killedBy,omitempty"`
+	SurvivalTicks uint64         `json:"survivalTicks"`
+	SurvivalTime  int64          `json:"survivalTime"` // Milliseconds
+}
+
+// GameResults is sent with gameover. Players are ranked by how long they
+// survived, then by score; players who tie share a rank.
+type GameResults struct {
+	Winner       string         `json:"winner,omitempty"`
+	WinCondition WinCondition   `json:"winCondition"`
+	Ticks        uint64         `json:"ticks"`
+	Players      []PlayerResult `json:"players"`
+}
+
+// startStats starts tracking every snake in the game that is starting.
+func (r *Room) startStats() {
+	r.startTick = r.tick
+	r.stats = make(map[string]*PlayerStats, len(r.snakesMap))
+	for id, player := range r.snakesMap {
+		r.stats[id] = &PlayerStats{
+			ID:        id,
+			Name:      player.Name,
+			FoodEaten: make(map[string]int),
+		}
+	}
+}
+
+func (r *Room) recordFood(playerId string, food string) {
+	if stats, exists := r.stats[playerId]; exists && food != "" {
+		stats.FoodEaten[food]++
+	}
+}
+
+// recordDeath notes how and when the player's game ended, with their final
+// score and length, credits the kill and tells the room. Head-on deaths name
+// the other snake but credit no kill, since both die.
+func (r *Room) recordDeath(playerId string, cause DeathCause, killedBy string) {
+	stats, exists := r.stats[playerId]
+	if !exists || stats.Dead {
+		return
+	}
+
+	stats.Dead = true
+	stats.Cause = cause
+	stats.KilledBy = killedBy
+	stats.DiedAt = r.tick
+	if player, exists := r.snakesMap[playerId]; exists {
+		stats.Score = player.Snake.Score
+		stats.Length = player.Snake.Size + 1
+	}
+
+	if killer, exists := r.stats[killedBy]; exists && cause == DeathCollision {
+		killer.Kills++
+	}
+
+	r.broadcast(&SnakeDiedMessage{
+		Event:  "snakeDied",
+		ID:     playerId,
+		Cause:  cause,
+		Killer: killedBy,
+	})
+}
+
+// gameResults ranks the players of the game that just ended.
+func (r *Room) gameResults() GameResults {
+	players := make([]PlayerResult, 0, len(r.stats))
+	for _, stats := range r.stats {
+		diedAt := stats.DiedAt
+		if !stats.Dead {
+			diedAt = r.tick
+		}
+		survival := diedAt - r.startTick
+		players = append(players, PlayerResult{
+			ID:            stats.ID,
+			Name:          stats.Name,
+			Score:         stats.Score,
+			Length:        stats.Length,
+			FoodEaten:     stats.FoodEaten,
+			Kills:         stats.Kills,
+			Cause:         stats.Cause,
+			KilledBy:      stats.KilledBy,
+			SurvivalTicks: survival,
+			SurvivalTime:  int64(survival) * 1000 / int64(max(GameConfigJSON.Fps, 1)),
+		})
+	}
+
+	slices.SortFunc(players, func(a, b PlayerResult) int {
+		return cmp.Or(
+			cmp.Compare(b.SurvivalTicks, a.SurvivalTicks),
+			cmp.Compare(b.Score, a.Score),
+			cmp.Compare(a.ID, b.ID),
+		)
+	})
+	for i := range players {
+		players[i].Rank = i + 1
+		if i > 0 && sameRank(players[i-1], players[i]) {
+			players[i].Rank = players[i-1].Rank
+		}
+	}
+
+	results := GameResults{
+		Ticks:   r.tick - r.startTick,
+		Players: players,
+	}
+	results.WinCondition = winCondition(players)
+	if len(players) > 0 && results.WinCondition != WinDraw && results.WinCondition != WinAbandoned {
+		results.Winner = players[0].ID
+	}
+	return results
+}
+
+func sameRank(a, b PlayerResult) bool {
+	return a.SurvivalTicks == b.SurvivalTicks && a.Score == b.Score
+}
+
+func winCondition(ranked []PlayerResult) WinCondition {
+	left := 0
+	for _, player := range ranked {
+		if player.Cause == DeathLeft {
+			left++
+		}
+	}
+
+	switch {
+	case left == len(ranked):
+		return WinAbandoned
+	case len(ranked) == 1:
+		return WinSolo
+	case ranked[0].SurvivalTicks > ranked[1].SurvivalTicks:
+		return WinLastStanding
+	case ranked[0].Score > ranked[1].Score:
+		return WinScore
+	default:
+		return WinDraw
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestGameResults(t *testing.T) {
+	type death struct {
+		tick   uint64
+		id     string
+		cause  DeathCause
+		killer string
+		score  int
+	}
+
+	tests := []struct {
+		name      string
+		players   []string
+		deaths    []death
+		end       uint64 // Tick the game ended on
+		winner    string
+		condition WinCondition
+		ranks     map[string]int
+	}{
+		{
+			name:      "left before the first tick",
+			players:   []string{"a", "b"},
+			deaths:    []death{{0, "a", DeathLeft, "", 0}, {40, "b", DeathWall, "", 0}},
+			end:       40,
+			winner:    "b",
+			condition: WinLastStanding,
+			ranks:     map[string]int{"b": 1, "a": 2},
+		},
+		{
+			name:      "killed",
+			players:   []string{"a", "b"},
+			deaths:    []death{{10, "b", DeathCollision, "a", 100}, {25, "a", DeathSelf, "", 0}},
+			end:       25,
+			winner:    "a",
+			condition: WinLastStanding,
+			ranks:     map[string]int{"a": 1, "b": 2},
+		},
+		{
+			name:      "died together",
+			players:   []string{"a", "b", "c"},
+			deaths:    []death{{5, "c", DeathWall, "", 500}, {30, "a", DeathHeadOn, "b", 100}, {30, "b", DeathHeadOn, "a", 200}},
+			end:       30,
+			winner:    "b",
+			condition: WinScore,
+			ranks:     map[string]int{"b": 1, "a": 2, "c": 3},
+		},
+		{
+			name:      "draw",
+			players:   []string{"a", "b"},
+			deaths:    []death{{30, "a", DeathHeadOn, "b", 100}, {30, "b", DeathHeadOn, "a", 100}},
+			end:       30,
+			condition: WinDraw,
+			ranks:     map[string]int{"a": 1, "b": 1},
+		},
+		{
+			name:      "everyone left",
+			players:   []string{"a", "b"},
+			deaths:    []death{{0, "a", DeathLeft, "", 0}, {3, "b", DeathLeft, "", 0}},
+			end:       3,
+			condition: WinAbandoned,
+			ranks:     map[string]int{"b": 1, "a": 2},
+		},
+		{
+			name:      "solo",
+			players:   []string{"a"},
+			deaths:    []death{{0, "a", DeathWall, "", 0}},
+			end:       0,
+			winner:    "a",
+			condition: WinSolo,
+			ranks:     map[string]int{"a": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRoom("room_test", gameModes["classic"], NewRoomManager())
+			for _, id := range tt.players {
+				r.snakesMap[id] = Player{ID: id, Name: id}
+			}
+			r.startStats()
+
+			for _, death := range tt.deaths {
+				r.tick = r.startTick + death.tick
+				player := r.snakesMap[death.id]
+				player.Snake.Score = death.score
+				r.snakesMap[death.id] = player
+				r.recordDeath(death.id, death.cause, death.killer)
+			}
+			r.tick = r.startTick + tt.end
+
+			results := r.gameResults()
+			if results.Winner != tt.winner || results.WinCondition != tt.condition {
+				t.Errorf("winner %q by %s, want %q by %s", results.Winner, results.WinCondition, tt.winner, tt.condition)
+			}
+			ranks := make(map[string]int)
+			for _, player := range results.Players {
+				ranks[player.ID] = player.Rank
+			}
+			if !maps.Equal(ranks, tt.ranks) {
+				t.Errorf("ranks %v, want %v", ranks, tt.ranks)
+			}
+		})
+	}
+}
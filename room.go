@@ -1,37 +1,231 @@
 package main
 
 import (
-	"encoding/json"
+	"go/ws/game"
 	"log"
 	"maps"
+	"math/rand"
 	"slices"
-	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
-// Room structure to hold room data.
+// Room structure to hold room data. A room is an actor: its state is owned by
+// the goroutine the RoomManager starts and only touched from there. Other
+// goroutines use the command methods (join, leave, addPlayer, start, ...),
+// which run their work on the room goroutine and wait for it.
 type Room struct {
-	id                string
-	playersMutex      sync.Mutex
-	players           []*websocket.Conn
-	snakesMap         map[string]Player
-	snakesMapMutex    sync.Mutex
-	nextPositionIndex int
-	waitingRoom       map[string]Player
-	waitingRoomMutex  sync.Mutex
-	hasGameStarted    bool
-	aliveCount        int
-	FoodCoordinates   [][]any
-}
-
-var rooms = make(map[string]*Room)
-var roomsMutex sync.Mutex
+	id        string
+	mode      *GameMode
+	createdAt time.Time
+	manager   *RoomManager
+	private   bool   // Left out of matchmaking, joined by code
+	joinCode  string // Set for private rooms
+	password  string // Optional, for private rooms
+	summary   atomic.Pointer[RoomSummary]
+	commands  chan func()
+	done      chan struct{} // Closed when the room goroutine exits
+
+	players         []*Client
+	spectators      []*Client // Never counted as players.
+	hostId          string    // Player who may start the game, see host.go
+	kicked          map[string]bool
+	rematchVotes    map[string]bool         // Players who want to play again, see rematch.go
+	stats           map[string]*PlayerStats // Per player for the current game, see results.go
+	startTick       uint64                  // Tick the current game started on
+	seed            int64                   // Seed of rng for the current game
+	rng             *rand.Rand              // All randomness in a game comes from here, see reseed
+	recording       *Replay                 // The game being recorded, see replay.go
+	playback        *playback               // Set for rooms that play a replay, see playback.go
+	snakesMap       map[string]Player
+	waitingRoom     map[string]Player
+	state           RoomState
+	timeout         *time.Timer   // Fires the current state's timeout, if any
+	lobbyTimer      bool          // The waiting timeout is the lobby timer, see lobby.go
+	countdownLeft   time.Duration // Time left after the current countdown step
+	aliveCount      int
+	FoodCoordinates [][]any
+	tick            uint64              // Game loop iterations, only ever increases
+	lastSnakes      map[string]Player   // Snakes as of the last broadcast, for deltas
+	inputs          map[string][]Vector // Queued direction changes per player
+	ticker          *time.Ticker        // Drives the game loop while a game is running
+}
 
 // position vars only 4 positions for now
 var startingPositions = []struct{ x, y int }{
-	{5, 5}, {15, 5}, {15, 5}, {15, 15},
+	{5, 5}, {15, 5}, {5, 15}, {15, 15},
+}
+
+func newRoom(id string, mode *GameMode, manager *RoomManager) *Room {
+	r := &Room{
+		id:           id,
+		mode:         mode,
+		createdAt:    time.Now(),
+		manager:      manager,
+		state:        RoomWaiting,
+		commands:     make(chan func()),
+		done:         make(chan struct{}),
+		waitingRoom:  make(map[string]Player),
+		kicked:       make(map[string]bool),
+		rematchVotes: make(map[string]bool),
+		snakesMap:    make(map[string]Player),
+		inputs:       make(map[string][]Vector),
+	}
+	r.reseed()
+	r.updateWaitingTimeout()
+	return r
+}
+
+// reseed picks a new seed for the next game and lays out its food. Food,
+// starting positions and server snake moves all come from r.rng, so a game
+// can be played again from its seed and the players' inputs.
+func (r *Room) reseed() {
+	r.useSeed(rand.Int63())
+}
+
+// useSeed restarts the room's random numbers from seed and lays out food
+// from them.
+func (r *Room) useSeed(seed int64) {
+	r.seed = seed
+	r.rng = rand.New(rand.NewSource(seed))
+	r.FoodCoordinates = game.GenerateFood(r.rng, GameConfigJSON.ScaleFactor, GameConfigJSON.FoodStorage)
+}
+
+// run is the room goroutine. It executes commands one at a time, advances the
+// game on every tick while a game is running and exits once the room closes.
+func (r *Room) run() {
+	defer close(r.done)
+
+	r.publishSummary()
+	for r.state != RoomClosed {
+		var tick, timeout <-chan time.Time
+		if r.ticker != nil {
+			tick = r.ticker.C
+		}
+		if r.timeout != nil {
+			timeout = r.timeout.C
+		}
+
+		select {
+		case command := <-r.commands:
+			command()
+		case <-tick: // Main game loop running at FPS rate
+			r.tickGame()
+		case <-timeout:
+			r.timeout = nil
+			r.lobbyTimer = false
+			r.handleTimeout()
+		}
+		r.publishSummary()
+	}
+}
+
+// setState moves the room to a new state, runs the entry actions for it and
+// tells clients and transition hooks about the change.
+func (r *Room) setState(to RoomState) {
+	from := r.state
+	if !canTransition(from, to) {
+		log.Printf("Room %s cannot go from %s to %s", r.id, from, to)
+		return
+	}
+	r.state = to
+	r.stopTimeout()
+	if from == RoomPlaying {
+		r.saveRecording()
+	}
+
+	switch to {
+	case RoomWaiting:
+		if from == RoomFinished {
+			r.resetForRematch()
+		}
+		r.updateWaitingTimeout()
+	case RoomCountdown:
+		r.startCountdown()
+	case RoomPlaying:
+		r.startGame()
+	case RoomFinished:
+		r.stopTicker()
+		results := r.gameResults()
+		if r.playback == nil {
+			ratings.Update(results)
+		}
+		r.broadcast(&GameOverMessage{
+			Event:   "gameover",
+			Results: results,
+		})
+		r.armTimeout(Settings.FinishedRoomTimeout)
+	case RoomClosed:
+		r.stopTicker()
+	}
+
+	log.Printf("Room %s: %s -> %s", r.id, from, to)
+	r.broadcast(&RoomStateMessage{
+		Event:    "roomState",
+		RoomID:   r.id,
+		State:    to,
+		Previous: from,
+	})
+	if to == RoomClosed {
+		// Gone from the manager before the hooks run, so nobody finds it after
+		// hearing it closed
+		r.manager.remove(r)
+	}
+	for _, hook := range r.manager.transitionHooks() {
+		hook(r, from, to)
+	}
+
+	if to == RoomClosed {
+		r.players = nil
+		r.spectators = nil
+		r.snakesMap = nil
+		r.FoodCoordinates = nil
+	}
+}
+
+// handleTimeout moves the room on when it has spent too long in a state.
+func (r *Room) handleTimeout() {
+	switch r.state {
+	case RoomWaiting:
+		r.handleLobbyTimeout()
+	case RoomCountdown:
+		r.countdownStep()
+	case RoomFinished:
+		r.setState(RoomClosed)
+	}
+}
+
+func (r *Room) armTimeout(d time.Duration) {
+	r.stopTimeout()
+	r.timeout = time.NewTimer(d)
+}
+
+func (r *Room) stopTimeout() {
+	if r.timeout != nil {
+		r.timeout.Stop()
+		r.timeout = nil
+	}
+	r.lobbyTimer = false
+}
+
+func (r *Room) stopTicker() {
+	if r.ticker != nil {
+		r.ticker.Stop()
+		r.ticker = nil
+	}
+}
+
+// do runs fn on the room goroutine and waits for it to finish. It reports
+// false if the room has closed. fn must not call do itself.
+func (r *Room) do(fn func()) bool {
+	finished := make(chan struct{})
+	select {
+	case r.commands <- func() { fn(); close(finished) }:
+		<-finished
+		return true
+	case <-r.done:
+		return false
+	}
 }
 
 func (r *Room) serverSnake() {
@@ -53,245 +247,485 @@ func (r *Room) serverSnake() {
 	r.addToWaitingRoom(serverPlayer)
 }
 
-func (r *Room) startGameLoop() {
-	ticker := time.NewTicker(time.Second / time.Duration(GameConfigJSON.Fps))
-	defer ticker.Stop()
-
-	// ticker for server snake
-	moveTicker := time.NewTicker(3 * time.Second)
-	defer moveTicker.Stop()
-
-	for {
-		select {
-		case <-ticker.C: // Main game loop running at FPS rate
-			if !r.hasGameStarted {
-				println("game not started")
-				return
-			}
-
-			/* r.snakesMapMutex.Lock()
-			defer r.snakesMapMutex.Unlock() */
+// tickGame advances the game by one step.
+func (r *Room) tickGame() {
+	if r.playback != nil {
+		r.applyReplayEvents()
+	}
+	r.tick++
+	ids := slices.Sorted(maps.Keys(r.snakesMap))
+
+	snakes := make(map[string]Snake, len(ids))
+	var moving []string
+	r.aliveCount = 0
+	for _, id := range ids {
+		player := r.snakesMap[id]
+		if !player.Snake.IsDead {
+			r.aliveCount++
+		}
 
-			r.aliveCount = 0
+		// Snakes of disconnected players stay frozen until they reconnect
+		if !player.Snake.IsDead && !player.Disconnected {
+			r.applyNextInput(id, &player.Snake)
+			moving = append(moving, id)
+		}
+		snakes[id] = player.Snake
+	}
 
-			for key, player := range r.snakesMap {
-				if player.Snake.IsDead {
-					continue
-				}
+	board := r.board()
+	result := board.Step(snakes, moving)
+	r.FoodCoordinates = board.Food
 
-				player.Snake.Update(r)
-				r.snakesMap[key] = player
-				r.aliveCount++
-			}
+	for _, food := range result.Food {
+		r.broadcast(&FoodUpdateMessage{
+			Event: "updateFood",
+			Food:  [][]any{food},
+		})
+	}
+	for _, id := range ids {
+		player := r.snakesMap[id]
+		player.Snake = snakes[id]
+		r.snakesMap[id] = player
+		r.recordFood(id, result.Eaten[id])
+	}
+	for _, id := range result.Died {
+		r.recordDeath(id, snakes[id].Cause, snakes[id].KilledBy)
+	}
 
-			if r.aliveCount <= 0 {
-				gameOverMessage := EventMessage{
-					Event: "gameover",
-				}
-				r.broadcast(gameOverMessage)
-				r.hasGameStarted = false
-				r.players = nil
-				r.snakesMap = nil
-				r.FoodCoordinates = nil
-
-				roomID := r.id
-				log.Printf("Deleting room %s\n", roomID)
-				delete(rooms, roomID)
-
-				return
-			}
+	r.checkTick()
+	if r.aliveCount <= 0 || r.playbackOver() {
+		r.setState(RoomFinished)
+		return
+	}
 
-			message := SnakeUpdateMessage{
-				Event:     "snake_update",
-				SnakesMap: r.snakesMap,
-			}
-			r.broadcast(message)
+	r.broadcastSnakes()
+}
 
-		case <-moveTicker.C: //move server snake every 3 seconds
-			//	r.moveSnake()
-		}
+// board is the room's game as the game package sees it.
+func (r *Room) board() *game.Board {
+	return &game.Board{
+		Size:  GameConfigJSON.ScaleFactor,
+		Walls: r.mode.Walls,
+		Food:  r.FoodCoordinates,
+		Rng:   r.rng,
 	}
 }
 
 // Add player to the waiting room
 func (r *Room) addToWaitingRoom(player Player) {
-	r.waitingRoomMutex.Lock()
-	defer r.waitingRoomMutex.Unlock()
+	r.waitingRoom[player.ID] = player
+}
 
-	// Assign a starting position
-	if r.nextPositionIndex < len(startingPositions) {
-		player.Snake.X = startingPositions[r.nextPositionIndex].x
-		player.Snake.Y = startingPositions[r.nextPositionIndex].y
-		r.nextPositionIndex++
-	} else {
-		// Handle case where there are more players than predefined positions
-		player.Snake.X = 0 // Default position if needed
-		player.Snake.Y = 0
+// placeSnakes gives every snake its starting position, shuffled with the
+// room's RNG and handed out in player ID order.
+func (r *Room) placeSnakes() {
+	positions := r.rng.Perm(len(startingPositions))
+	for i, id := range slices.Sorted(maps.Keys(r.snakesMap)) {
+		player := r.snakesMap[id]
+		if i < len(positions) {
+			player.Snake.X = startingPositions[positions[i]].x
+			player.Snake.Y = startingPositions[positions[i]].y
+		} else {
+			// Handle case where there are more players than predefined positions
+			player.Snake.X = 0
+			player.Snake.Y = 0
+		}
+		r.snakesMap[id] = player
 	}
-
-	r.waitingRoom[player.ID] = player
 }
 
 // Remove player from the waiting room
 func (r *Room) removeFromWaitingRoom(playerID string) {
-	r.waitingRoomMutex.Lock()
 	delete(r.waitingRoom, playerID)
-	r.waitingRoomMutex.Unlock()
 }
 
 // Broadcast the current waiting room status
 func (r *Room) broadcastWaitingRoomStatus() {
-	r.waitingRoomMutex.Lock()
-	defer r.waitingRoomMutex.Unlock()
+	r.broadcast(r.waitingRoomStatus())
+}
+
+func (r *Room) waitingRoomStatus() *WaitingRoomStatusMessage {
 	players := make([]Player, 0, len(r.waitingRoom))
 	for _, player := range r.waitingRoom {
 		players = append(players, player)
 	}
 
-	// Assign players to JSON and include in the message
-	messageBytes, err := json.Marshal(struct {
-		Event   string   `json:"event"`
-		Players []Player `json:"players"`
-	}{
+	return &WaitingRoomStatusMessage{
 		Event:   "waitingRoomStatus",
 		Players: players,
-	})
-	if err != nil {
-		log.Println("Error encoding waiting room status:", err)
-		return
-	}
-
-	// Broadcast the updated waiting room status
-	clientsMutex.Lock()
-	defer clientsMutex.Unlock()
-
-	for _, conn := range r.players {
-		err := conn.WriteMessage(websocket.TextMessage, messageBytes)
-		if err != nil {
-			log.Println("Error sending waiting room status to client:", err)
-			conn.Close()
-			delete(clients, conn)
-		}
+		HostID:  r.hostId,
 	}
 }
 
-// Start the game when all players are ready
+// Start the game when the countdown is over
 func (r *Room) startGame() {
-	r.nextPositionIndex = 0
-	r.hasGameStarted = true
-
-	message := EventMessage{
+	log.Printf("Room %s starting game with seed %d", r.id, r.seed)
+	message := &GameStartMessage{
 		Event: "startGame",
+		Seed:  r.seed,
 	}
 
 	r.broadcast(message)
 
 	// Move players from waitingRoom to snakesMap
-	r.waitingRoomMutex.Lock()
-	r.snakesMapMutex.Lock()
 	maps.Copy(r.snakesMap, r.waitingRoom)
 	r.waitingRoom = make(map[string]Player) // Clear waiting room
-	r.waitingRoomMutex.Unlock()
-	r.snakesMapMutex.Unlock()
+	r.placeSnakes()
+	r.startStats()
+	r.startRecording()
 
-	go r.startGameLoop()
+	r.ticker = time.NewTicker(time.Second / time.Duration(GameConfigJSON.Fps))
 }
 
-func (r *Room) sendConfig(conn *websocket.Conn) {
+func (r *Room) sendConfig(client *Client) {
 
-	GameConfigJSON.BackgroundNumber = randomNumber()
-	configMessage := ConfigMessage{
+	// Each client gets its own copy so the shared config is never written
+	config := GameConfigJSON
+	config.BackgroundNumber = randomNumber()
+	configMessage := &ConfigMessage{
 		Event:  "config",
-		Config: &GameConfigJSON,
+		Config: &config,
 		Food:   r.FoodCoordinates,
+		Mode:   r.mode,
 	}
-	msgBytes, err := json.Marshal(configMessage)
-	if err != nil {
-		log.Println("Error encoding config message:", err)
-		return
+	r.send(client, configMessage)
+}
+
+// frame stamps messages with the current tick and server time.
+func (r *Room) frame() Frame {
+	return Frame{
+		Tick:       r.tick,
+		ServerTime: time.Now().UnixMilli(),
 	}
-	err = conn.WriteMessage(websocket.TextMessage, msgBytes)
-	if err != nil {
-		log.Println("Error sending config to client:", err)
-		conn.Close()
-		clientsMutex.Lock()
-		delete(clients, conn)
-		clientsMutex.Unlock()
+}
+
+// send delivers a message from this room to a single client.
+func (r *Room) send(client *Client, message BroadcastMessage) {
+	if framed, ok := message.(framedMessage); ok {
+		framed.setFrame(r.frame())
 	}
+	client.send(message)
 }
 
-// Broadcast message to all connected clients
+// Broadcast message to all connected clients and spectators, stamped with the
+// current frame if it has one. The message is encoded once per codec and
+// queued on every client, so a slow client never blocks the game loop.
 func (r *Room) broadcast(message BroadcastMessage) {
-	msgBytes, err := json.Marshal(message)
-	if err != nil {
-		log.Println("Error encoding message:", err)
-		return
+	if framed, ok := message.(framedMessage); ok {
+		framed.setFrame(r.frame())
 	}
 
-	playersCopy := slices.Clone(r.players)
-
-	for i, conn := range playersCopy {
-
-		err := conn.WriteMessage(websocket.TextMessage, msgBytes)
-		if err != nil {
-			log.Println("Error sending message to client:", err)
-
-			r.playersMutex.Lock()
-			conn.Close()
-			delete(clients, conn)
-			r.players = slices.Delete(r.players, i, i+1)
-			r.playersMutex.Unlock()
+	encoded := make(map[*Codec][]byte)
+	for _, client := range r.recipients() {
+		client.sendEncoded(message, encoded)
+	}
+}
 
-			log.Printf("Player at index %d removed from the game due to an error.\n", i)
+// broadcastSnakes sends this tick's snake state. Clients that opted in to
+// deltas get a snake_delta, except on keyframe ticks or when they need to
+// resync; everyone else gets the full snake_update.
+func (r *Room) broadcastSnakes() {
+	frame := r.frame()
+	full := SnakeUpdateMessage{
+		Event:     "snake_update",
+		Frame:     frame,
+		SnakesMap: r.snakesMap,
+	}
+	keyframe := r.lastSnakes == nil || r.tick%uint64(Settings.KeyframeInterval) == 0
+
+	var delta SnakeDeltaMessage
+	if !keyframe {
+		delta = SnakeDeltaMessage{
+			Event:    "snake_delta",
+			Frame:    frame,
+			BaseTick: r.tick - 1,
+			Snakes:   diffSnakes(r.lastSnakes, r.snakesMap),
+		}
+	}
+	r.lastSnakes = copySnakes(r.snakesMap)
+
+	fullEncoded := make(map[*Codec][]byte)
+	deltaEncoded := make(map[*Codec][]byte)
+	for _, client := range r.recipients() {
+		needsKeyframe := client.needsKeyframe.Swap(false)
+		if keyframe || !client.deltaUpdates || needsKeyframe {
+			client.sendEncoded(full, fullEncoded)
+		} else {
+			client.sendEncoded(delta, deltaEncoded)
 		}
 	}
 }
 
+func (r *Room) recipients() []*Client {
+	return slices.Concat(r.players, r.spectators)
+}
+
 func (r *Room) moveSnake() {
 	player := r.snakesMap["Server"]
-	player.Snake.Speed.X, player.Snake.Speed.Y = getRandomDirection(player.Snake.Speed.X, player.Snake.Speed.Y)
+	player.Snake.Speed.X, player.Snake.Speed.Y = getRandomDirection(r.rng, player.Snake.Speed.X, player.Snake.Speed.Y)
 	r.snakesMap["Server"] = player
 }
 
-func (r *Room) handleDisconnection(conn *websocket.Conn) {
+func (r *Room) handleDisconnection(client *Client) {
 
-	r.removePlayerConnection(conn)
+	r.leave(client)
 
 	clientsMutex.Lock()
-	client, exists := clients[conn]
-	if !exists {
+	if clients[client.conn] != client {
 		clientsMutex.Unlock()
 		log.Println("Client not found in map")
 		return
 	}
 	playerId := client.playerId
 
-	delete(clients, conn)
+	delete(clients, client.conn)
 	clientsMutex.Unlock()
 	log.Println("Client removed from clients map")
 
-	r.snakesMapMutex.Lock()
-	delete(r.snakesMap, playerId)
-	r.snakesMapMutex.Unlock()
-
-	if !r.hasGameStarted {
-		r.removeFromWaitingRoom(playerId)
-		r.broadcastWaitingRoomStatus()
+	if suspendSession(client, r) {
+		return
 	}
+
+	r.removePlayer(playerId)
 }
 
-func (r *Room) removePlayerConnection(conn *websocket.Conn) {
+// The methods below are the room's commands. They are safe to call from any
+// goroutine.
 
-	r.playersMutex.Lock()
-	defer r.playersMutex.Unlock()
+// join adds a player connection if the room has space and has not started.
+func (r *Room) join(client *Client) bool {
+	joined := false
+	r.do(func() {
+		joined = r.seat(client)
+	})
+	return joined
+}
 
-	for i, playerConn := range r.players {
-		if playerConn == conn {
+// seat adds a player connection if the room is waiting and has space. It runs
+// on the room goroutine, or before the room is started and published.
+func (r *Room) seat(client *Client) bool {
+	if len(r.players) >= r.mode.Capacity || r.state != RoomWaiting || r.kicked[client.playerId] {
+		return false
+	}
+	r.players = append(r.players, client)
+	r.updateWaitingTimeout()
+	if r.hostId == "" {
+		r.setHost(client.playerId)
+	}
+	return true
+}
 
+// seatAll seats the first players of a room that is not running yet.
+func (r *Room) seatAll(players []*Client) {
+	for _, client := range players {
+		if !r.seat(client) {
+			log.Printf("Could not seat player %s in new room %s", client.playerId, r.id)
+		}
+	}
+}
+
+// leave removes a player connection without touching the player's snake.
+func (r *Room) leave(client *Client) {
+	r.do(func() {
+		if i := slices.Index(r.players, client); i >= 0 {
 			r.players = slices.Delete(r.players, i, i+1)
 			log.Printf("Removed connection from players in room %s", r.id)
+			if client.playerId == r.hostId {
+				r.migrateHost()
+			}
+			r.updateWaitingTimeout()
+			r.checkRematch()
+			return
+		}
+		log.Printf("Connection not found in players list for room %s", r.id)
+	})
+}
+
+// removePlayer takes the player's snake out of the game or waiting room.
+func (r *Room) removePlayer(playerId string) {
+	r.do(func() {
+		if player, exists := r.snakesMap[playerId]; exists && r.state == RoomPlaying {
+			if !player.Snake.IsDead {
+				r.recordDeath(playerId, DeathLeft, "")
+			}
+			r.recordEvent(r.tick+1, playerId, ReplayLeave, nil)
+		}
+		delete(r.inputs, playerId)
+		delete(r.snakesMap, playerId)
+
+		if r.state == RoomWaiting || r.state == RoomCountdown {
+			r.removeFromWaitingRoom(playerId)
+			r.broadcastWaitingRoomStatus()
+			r.updateWaitingTimeout()
+			r.checkReady()
+		}
+	})
+}
+
+// holdForReconnect freezes the player's snake if they are alive in a running
+// game, and reports whether it did.
+func (r *Room) holdForReconnect(playerId string) bool {
+	held := false
+	r.do(func() {
+		player, exists := r.snakesMap[playerId]
+		if r.state != RoomPlaying || !exists || player.Snake.IsDead {
+			return
+		}
+		player.Disconnected = true
+		r.snakesMap[playerId] = player
+		r.recordEvent(r.tick+1, playerId, ReplayFreeze, nil)
+		held = true
+	})
+	return held
+}
+
+// rejoin attaches a reconnected player's new connection, unfreezes their
+// snake and brings the client up to date. During a game the next
+// snake_update carries the full snakes map.
+func (r *Room) rejoin(client *Client) bool {
+	return r.do(func() {
+		r.players = append(r.players, client)
+		if r.hostId == "" {
+			r.setHost(client.playerId)
+		}
+
+		if player, exists := r.snakesMap[client.playerId]; exists {
+			player.Disconnected = false
+			r.snakesMap[client.playerId] = player
+			if r.state == RoomPlaying {
+				r.recordEvent(r.tick+1, client.playerId, ReplayUnfreeze, nil)
+			}
+		}
+
+		r.sendConfig(client)
+		if r.state == RoomWaiting || r.state == RoomCountdown {
+			r.broadcastWaitingRoomStatus()
+		}
+	})
+}
+
+// addPlayer puts a player who sent newPlayer in the waiting room.
+func (r *Room) addPlayer(client *Client, player Player) error {
+	var err error
+	ok := r.do(func() {
+		if r.state != RoomWaiting {
+			err = eventError(ErrGameStarted, "The game has already started")
+			return
+		}
+		r.addToWaitingRoom(player)
+		r.broadcastWaitingRoomStatus()
+		r.sendConfig(client)
+		r.updateWaitingTimeout()
+		r.checkReady()
+	})
+	if !ok {
+		return eventError(ErrRoomNotFound, "Room %s not found", r.id)
+	}
+	return err
+}
+
+func (r *Room) updateColours(playerId string, colours Colours) error {
+	var err error
+	ok := r.do(func() {
+		snake, exists := r.waitingRoom[playerId]
+		if !exists {
+			err = eventError(ErrNotInWaitingRoom, "Player not found in waiting room")
 			return
 		}
+		snake.Colours.Body = colours.Body
+		snake.Colours.Head = colours.Head
+		snake.Colours.Eyes = colours.Eyes
+		r.waitingRoom[playerId] = snake
+		r.broadcastWaitingRoomStatus()
+	})
+	if !ok {
+		return eventError(ErrRoomNotFound, "Room %s not found", r.id)
+	}
+	return err
+}
+
+func (r *Room) requestWaitingRoomStatus() {
+	r.do(r.broadcastWaitingRoomStatus)
+}
+
+func (r *Room) start() error {
+	var err error
+	ok := r.do(func() {
+		if r.state != RoomWaiting {
+			err = eventError(ErrGameStarted, "The game has already started")
+			return
+		}
+		if len(r.waitingRoom) == 0 {
+			err = eventError(ErrRoomEmpty, "There are no players in the waiting room")
+			return
+		}
+		if len(r.waitingRoom) < r.mode.MinPlayers {
+			err = eventError(ErrNotEnoughPlayers, "%s needs %d players to start", r.mode.Name, r.mode.MinPlayers)
+			return
+		}
+		log.Printf("Starting game on room: %s", r.id)
+		r.setState(RoomCountdown)
+	})
+	if !ok {
+		return eventError(ErrRoomNotFound, "Room %s not found", r.id)
 	}
-	log.Printf("Connection not found in players list for room %s", r.id)
+	return err
+}
+
+func (r *Room) isGameStarted() bool {
+	started := false
+	r.do(func() {
+		started = r.state != RoomWaiting
+	})
+	return started
+}
+
+// averageSkill is the mean rating of the connected players, used for matchmaking.
+func (r *Room) averageSkill() int {
+	average := 0
+	r.do(func() {
+		if len(r.players) == 0 {
+			return
+		}
+		total := 0
+		for _, client := range r.players {
+			total += ratings.Get(client.playerId)
+		}
+		average = total / len(r.players)
+	})
+	return average
+}
+
+// spectate adds a spectator and sends it what it needs to render the room.
+func (r *Room) spectate(client *Client) bool {
+	return r.do(func() {
+		client.needsKeyframe.Store(true)
+		r.spectators = append(r.spectators, client)
+		r.sendConfig(client)
+		if r.state == RoomWaiting || r.state == RoomCountdown {
+			r.send(client, r.waitingRoomStatus())
+		}
+		if r.playback != nil && r.state == RoomPlaying && r.ticker == nil {
+			r.ticker = time.NewTicker(r.playbackInterval())
+		}
+	})
+}
+
+func (r *Room) unspectate(client *Client) {
+	r.do(func() {
+		if i := slices.Index(r.spectators, client); i >= 0 {
+			r.spectators = slices.Delete(r.spectators, i, i+1)
+		}
+		// Nobody is left to watch the replay
+		if r.playback != nil && len(r.spectators) == 0 {
+			r.setState(RoomClosed)
+		}
+	})
+}
+
+// reply sends a message stamped with the room's current frame to one client.
+func (r *Room) reply(client *Client, message BroadcastMessage) bool {
+	return r.do(func() {
+		r.send(client, message)
+	})
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RoomSummary is what the lobby browser shows about a public room.
+type RoomSummary struct {
+	ID        string    `json:"id"`
+	Mode      string    `json:"mode"`
+	State     RoomState `json:"state"`
+	Players   int       `json:"players"`
+	Capacity  int       `json:"capacity"`
+	CreatedAt int64     `json:"createdAt"` // Unix milliseconds
+	Elapsed   int64     `json:"elapsed"`   // Seconds since the room was created
+}
+
+// publishSummary refreshes the summary other goroutines read without asking
+// the room, and tells lobby subscribers when it changed.
+func (r *Room) publishSummary() {
+	if r.state == RoomClosed {
+		return
+	}
+
+	summary := &RoomSummary{
+		ID:        r.id,
+		Mode:      r.mode.Name,
+		State:     r.state,
+		Players:   len(r.players),
+		Capacity:  r.mode.Capacity,
+		CreatedAt: r.createdAt.UnixMilli(),
+	}
+	if previous := r.summary.Load(); previous != nil && *previous == *summary {
+		return
+	}
+	r.summary.Store(summary)
+
+	if !r.private {
+		roomList.updated(*summary)
+	}
+}
+
+// Summary returns the room's latest summary. It is safe to call from any
+// goroutine.
+func (r *Room) Summary() (RoomSummary, bool) {
+	summary := r.summary.Load()
+	if summary == nil {
+		return RoomSummary{}, false
+	}
+	return summary.withElapsed(), true
+}
+
+func (s RoomSummary) withElapsed() RoomSummary {
+	s.Elapsed = int64(time.Since(time.UnixMilli(s.CreatedAt)).Seconds())
+	return s
+}
+
+// PublicRooms lists the summaries of all public rooms, oldest first.
+func (m *RoomManager) PublicRooms() []RoomSummary {
+	summaries := []RoomSummary{}
+	for _, room := range m.Rooms() {
+		if room.private {
+			continue
+		}
+		if summary, ok := room.Summary(); ok {
+			summaries = append(summaries, summary)
+		}
+	}
+	slices.SortFunc(summaries, func(a, b RoomSummary) int {
+		return int(a.CreatedAt - b.CreatedAt)
+	})
+	return summaries
+}
+
+// RoomList pushes room changes to clients that subscribed to the lobby.
+type RoomList struct {
+	mutex       sync.Mutex
+	subscribers map[*Client]bool
+}
+
+var roomList = &RoomList{subscribers: make(map[*Client]bool)}
+
+func (l *RoomList) subscribe(client *Client) {
+	l.mutex.Lock()
+	l.subscribers[client] = true
+	l.mutex.Unlock()
+}
+
+func (l *RoomList) unsubscribe(client *Client) {
+	l.mutex.Lock()
+	delete(l.subscribers, client)
+	l.mutex.Unlock()
+}
+
+// updated is called from the room goroutine whenever a public room's summary
+// changes, including when it is created.
+func (l *RoomList) updated(summary RoomSummary) {
+	l.broadcast(RoomUpdateMessage{
+		Event: "roomUpdate",
+		Room:  summary.withElapsed(),
+	})
+}
+
+// roomTransition is a TransitionHook that tells subscribers when a public
+// room closes.
+func (l *RoomList) roomTransition(room *Room, from, to RoomState) {
+	if to == RoomClosed && !room.private {
+		l.removed(room.id)
+	}
+}
+
+func (l *RoomList) removed(roomId string) {
+	l.broadcast(RoomRemovedMessage{
+		Event: "roomRemoved",
+		ID:    roomId,
+	})
+}
+
+func (l *RoomList) broadcast(message BroadcastMessage) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	encoded := make(map[*Codec][]byte)
+	for client := range l.subscribers {
+		client.sendEncoded(message, encoded)
+	}
+}
+
+// roomsHandler serves the public room list for lobby pages that do not hold a
+// WebSocket open.
+func roomsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roomManager.PublicRooms())
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RoomState is a step in a room's lifecycle.
+type RoomState string
+
+const (
+	RoomWaiting   RoomState = "waiting"   // Players gather in the waiting room
+	RoomCountdown RoomState = "countdown" // The game is about to start
+	RoomPlaying   RoomState = "playing"   // The game loop is running
+	RoomFinished  RoomState = "finished"  // The game is over, the room lingers
+	RoomClosed    RoomState = "closed"    // The room is gone for good
+)
+
+// roomTransitions lists the states each state may move to.
+var roomTransitions = map[RoomState][]RoomState{
+	RoomWaiting:   {RoomCountdown, RoomClosed},
+	RoomCountdown: {RoomPlaying, RoomWaiting, RoomClosed},
+	RoomPlaying:   {RoomFinished, RoomClosed},
+	RoomFinished:  {RoomWaiting, RoomClosed},
+	RoomClosed:    {},
+}
+
+func canTransition(from, to RoomState) bool {
+	return slices.Contains(roomTransitions[from], to)
+}
+
+// TransitionHook is called on the room goroutine after a room changes state.
+// Hooks must not call the room's command methods.
+type TransitionHook func(room *Room, from, to RoomState)
+
+// RoomManager owns the set of live rooms and hands out their IDs.
+type RoomManager struct {
+	mutex sync.Mutex
+	rooms map[string]*Room
+	codes map[string]*Room // Private rooms by join code
+	hooks []TransitionHook
+}
+
+// roomManager holds the server's rooms. The lobby room list hears about
+// closed rooms through a transition hook.
+var roomManager = newRoomManager()
+
+func newRoomManager() *RoomManager {
+	manager := NewRoomManager()
+	manager.OnTransition(roomList.roomTransition)
+	return manager
+}
+
+func NewRoomManager() *RoomManager {
+	return &RoomManager{
+		rooms: make(map[string]*Room),
+		codes: make(map[string]*Room),
+	}
+}
+
+// OnTransition registers a hook that runs on every room state change.
+func (m *RoomManager) OnTransition(hook TransitionHook) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.hooks = append(m.hooks, hook)
+}
+
+func (m *RoomManager) transitionHooks() []TransitionHook {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return slices.Clone(m.hooks)
+}
+
+func (m *RoomManager) Get(roomId string) (*Room, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	room, exists := m.rooms[roomId]
+	return room, exists
+}
+
+// ByCode finds a private room by its join code. Codes are not case sensitive.
+func (m *RoomManager) ByCode(code string) (*Room, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	room, exists := m.codes[strings.ToUpper(code)]
+	return room, exists
+}
+
+// Rooms returns the live rooms in no particular order.
+func (m *RoomManager) Rooms() []*Room {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return slices.Collect(maps.Values(m.rooms))
+}
+
+// Create starts a new room for the game mode with an ID no live room uses.
+// The players are seated before anyone else can find the room, at most
+// mode.Capacity of them.
+func (m *RoomManager) Create(mode *GameMode, players ...*Client) *Room {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	room := newRoom(m.unusedRoomId(), mode, m)
+	room.seatAll(players)
+	m.rooms[room.id] = room
+	go room.run()
+	return room
+}
+
+// CreatePrivate starts a room that matchmaking never picks. Players join it
+// with its join code and, if it is not empty, the password. The players are
+// seated as in Create.
+func (m *RoomManager) CreatePrivate(mode *GameMode, password string, players ...*Client) *Room {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	room := newRoom(m.unusedRoomId(), mode, m)
+	room.private = true
+	room.password = password
+	room.joinCode = newJoinCode()
+	for m.codes[room.joinCode] != nil {
+		room.joinCode = newJoinCode()
+	}
+	room.seatAll(players)
+
+	m.rooms[room.id] = room
+	m.codes[room.joinCode] = room
+	go room.run()
+	return room
+}
+
+// CreateReplay starts a room that plays a recorded game to spectators. It is
+// private, so matchmaking and the room list never offer it.
+func (m *RoomManager) CreateReplay(replay *Replay, speed float64) *Room {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	room := newRoom(m.unusedRoomId(), &replay.Mode, m)
+	room.private = true
+	room.startPlayback(replay, speed)
+
+	m.rooms[room.id] = room
+	go room.run()
+	return room
+}
+
+func (m *RoomManager) unusedRoomId() string {
+	roomId := newRoomId()
+	for m.rooms[roomId] != nil {
+		roomId = newRoomId()
+	}
+	return roomId
+}
+
+// remove forgets a closed room. Called from the room goroutine, so the
+// manager must never hold its mutex while waiting on a room.
+func (m *RoomManager) remove(room *Room) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.rooms[room.id] == room {
+		delete(m.rooms, room.id)
+		log.Printf("Deleting room %s", room.id)
+	}
+	if room.private && m.codes[room.joinCode] == room {
+		delete(m.codes, room.joinCode)
+	}
+}
+
+func newRoomId() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return "room_" + hex.EncodeToString(b)
+}
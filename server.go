@@ -2,12 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -17,6 +21,7 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all connections
 	},
+	Subprotocols: subprotocols(),
 }
 
 type BroadcastMessage interface {
@@ -35,207 +40,253 @@ type Player struct {
 	Snake   Snake   `json:"snake,omitempty"`
 	Colours Colours `json:"colours,omitempty"`
 	Type    string  `json:"type,omitempty"`
-}
-
-type Client struct {
-	isConnected bool
-	playerId    string
-	roomId      string // The room that the player belongs to.
+	Ready   bool    `json:"ready,omitempty"` // Set in the waiting room
+	// Set while the player is inside the reconnect grace period
+	Disconnected bool `json:"disconnected,omitempty"`
 }
 
 // Map to store connected clients
-var clients = make(map[*websocket.Conn]Client)
+var clients = make(map[*websocket.Conn]*Client)
 
 var clientsMutex sync.Mutex
-var serverSnakeCollision = false
 
-func handleConnections(w http.ResponseWriter, req *http.Request) {
-	// Extract the playerId from the URL query parameters
-	playerId := req.URL.Query().Get("playerId")
-	if playerId == "" {
-		log.Println("Player ID is missing in the URL")
-		http.Error(w, "Player ID is required", http.StatusBadRequest)
-		return
+// roomHTTPError answers a connection whose room lookup failed, before it is
+// upgraded.
+func roomHTTPError(w http.ResponseWriter, err error) {
+	var eventErr *EventError
+	if errors.As(err, &eventErr) && eventErr.Code == ErrWrongPassword {
+		http.Error(w, "Wrong room password", http.StatusForbidden)
+	} else {
+		http.Error(w, "Room not found", http.StatusNotFound)
 	}
+}
 
-	log.Printf("Player ID extracted: %s", playerId)
-
-	// Upgrade HTTP request to WebSocket
-	conn, err := upgrader.Upgrade(w, req, nil)
+func handleConnections(w http.ResponseWriter, req *http.Request) {
+	// The player ID comes from the signed token, never from the client directly
+	identity, err := identityFromRequest(req)
 	if err != nil {
-		log.Println("Error upgrading connection:", err)
+		log.Println("Rejected connection:", err)
+		http.Error(w, "A valid player token is required", http.StatusUnauthorized)
 		return
 	}
-	defer conn.Close()
-
-	// Check if player already has a connection
-	clientsMutex.Lock()
-	for existingConn, client := range clients {
-		if client.playerId == playerId {
-			log.Printf("Duplicate connection detected for player: %s", playerId)
-			existingConn.Close()
-			delete(clients, existingConn)
-		}
-	}
-	clientsMutex.Unlock()
+	playerId := identity.PlayerID
 
-	// Find or create a room for the player
-	roomId := findOrCreateRoom(conn, playerId)
-
-	// Lock the room and add the client
-	roomsMutex.Lock()
-	room, exists := rooms[roomId]
-	roomsMutex.Unlock()
+	log.Printf("Player authenticated: %s (%s)", playerId, identity.Name)
 
+	mode, exists := gameModeFor(req.URL.Query().Get("mode"))
 	if !exists {
-		log.Println("Failed to find or create room")
+		http.Error(w, "Unknown game mode", http.StatusBadRequest)
 		return
 	}
-
-	clientsMutex.Lock()
-	clients[conn] = Client{
-		isConnected: true,
-		playerId:    playerId,
-		roomId:      roomId,
+	var friends []string
+	if value := req.URL.Query().Get("friends"); value != "" {
+		friends = strings.Split(value, ",")
 	}
-	clientsMutex.Unlock()
-
-	log.Printf("Client %s connected to room: %s", clients[conn].playerId, roomId)
 
-	// Create a channel for received messages
-	messageChannel := make(chan []byte)
+	// Rooms are watched with ?spectate=<id>, private ones also need &code= and
+	// &password=
+	var spectatedRoom *Room
+	if roomId := req.URL.Query().Get("spectate"); roomId != "" {
+		spectatedRoom, err = findSpectatedRoom(roomId, req.URL.Query().Get("code"), req.URL.Query().Get("password"))
+		if err != nil {
+			roomHTTPError(w, err)
+			return
+		}
+	}
 
-	// Goroutine to read messages from the WebSocket
-	go func() {
-		for {
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				log.Println("Read error or client disconnected:", err)
-				room.handleDisconnection(conn)
-				break
+	// Recorded games are watched with ?replay=<id>, optionally &speed=
+	var replay *Replay
+	replaySpeed := 1.0
+	if replayId := req.URL.Query().Get("replay"); replayId != "" {
+		replay, err = openReplay(replayId)
+		if errors.Is(err, errReplayBoard) {
+			http.Error(w, "Replay was recorded on a different board", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "Replay not found", http.StatusNotFound)
+			return
+		}
+		if value := req.URL.Query().Get("speed"); value != "" {
+			replaySpeed, err = strconv.ParseFloat(value, 64)
+			if err != nil || !validReplaySpeed(replaySpeed) {
+				http.Error(w, "Invalid replay speed", http.StatusBadRequest)
+				return
 			}
-			messageChannel <- msg
 		}
-	}()
+	}
 
-	go func() {
-		for msg := range messageChannel {
-			processMessage(conn, msg)
+	// Private rooms are joined with ?code= and created with ?private=true,
+	// both with an optional &password=
+	var privateRoom *Room
+	password := req.URL.Query().Get("password")
+	if code := req.URL.Query().Get("code"); code != "" && spectatedRoom == nil {
+		privateRoom, err = findPrivateRoom(code, password)
+		if err != nil {
+			roomHTTPError(w, err)
+			return
 		}
-	}()
+	}
 
-	select {}
-}
+	// Upgrade HTTP request to WebSocket
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Println("Error upgrading connection:", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxMessageSize)
 
-// Process incoming messages
-func processMessage(conn *websocket.Conn, msg []byte) {
+	client := newClient(conn, identity)
+	client.deltaUpdates = req.URL.Query().Get("updates") == "delta"
+	go client.writePump()
+	defer client.close()
 
-	// Check if the message is a simple string before attempting to unmarshal it
-	strMsg := string(msg)
-	// p for ping
-	if strMsg == "p" {
+	var room *Room
+	if spectatedRoom != nil {
+		client.spectating = true
+		if !spectateRoom(client, spectatedRoom) {
+			return
+		}
+	} else if replay != nil {
+		client.spectating = true
+		if !watchReplay(client, replay, replaySpeed) {
+			return
+		}
+	} else {
+		// Reattach to an existing session if the client presents its resume token
+		room = resumeSession(client, req.URL.Query().Get("resumeToken"))
+	}
+
+	if room == nil && !client.spectating {
+		// Without a resume token a second connection starts over: the old
+		// connection is closed and the player leaves its room
 		clientsMutex.Lock()
-		err := conn.WriteMessage(websocket.TextMessage, []byte("p"))
+		for existingConn, existing := range clients {
+			if existing.playerId == playerId && !existing.spectating {
+				log.Printf("Duplicate connection detected for player: %s", playerId)
+				existing.close()
+				delete(clients, existingConn)
+			}
+		}
 		clientsMutex.Unlock()
-
-		if err != nil {
-			log.Println("Error sending pong:", err)
+		endSession(playerId)
+
+		switch {
+		case privateRoom != nil:
+			if !privateRoom.join(client) {
+				log.Printf("Player %s could not join room %s", playerId, privateRoom.id)
+				client.closeWith(websocket.CloseTryAgainLater, "Room is full or has started")
+				return
+			}
+			room = privateRoom
+		case req.URL.Query().Get("private") == "true":
+			room = roomManager.CreatePrivate(mode, password, client)
+		default:
+			// Find or create a room for the player, possibly after a wait in the queue
+			room = matchmaker.Match(client, mode, friends)
+			if room == nil {
+				log.Printf("Player %s left the %s queue", playerId, mode.Name)
+				return
+			}
 		}
-		return
-	}
+		roomId := room.id
 
-	var message Message
+		clientsMutex.Lock()
+		client.roomId = roomId
+		clients[conn] = client
+		clientsMutex.Unlock()
 
-	// Retrieve the room ID of the player
-	clientsMutex.Lock()
-	client := clients[conn]
-	roomId := client.roomId
-	clientsMutex.Unlock()
-	roomsMutex.Lock()
-	room, exists := rooms[roomId]
-	roomsMutex.Unlock()
+		startSession(client, room)
 
-	if !exists {
-		log.Printf("Room %s not found for player %s", roomId, message.Player.ID)
-		return
+		log.Printf("Client %s connected to room: %s", playerId, roomId)
 	}
 
-	if strings.HasPrefix(strMsg, "m:") {
-		parts := strings.Split(strMsg[2:], ":")
-		playerId := parts[0]
-		key := parts[1]
-
-		roomsMutex.Lock()
-		if player, exists := room.snakesMap[playerId]; exists {
-			if speed, ok := directionMap[key]; ok {
-				// check is snake is trying to move in the same axis
-				if (player.Snake.Speed.X != 0 && speed.X != 0) || (player.Snake.Speed.Y != 0 && speed.Y != 0) {
-					roomsMutex.Unlock()
-					return
+	// Every pong or message pushes the read deadline forward, so a client that
+	// misses MaxMissedHeartbeats pings in a row times out and gets evicted.
+	conn.SetReadDeadline(time.Now().Add(heartbeatTimeout()))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(heartbeatTimeout()))
+	})
+
+	for {
+		messageType, msg, err := conn.ReadMessage()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				log.Printf("Player %s missed %d heartbeats, evicting", playerId, Settings.MaxMissedHeartbeats)
+			} else {
+				log.Println("Read error or client disconnected:", err)
+			}
+			roomList.unsubscribe(client)
+			if client.spectating {
+				stopSpectating(client)
+			} else {
+				// The player may have moved to another room with joinRoom
+				if current, exists := roomManager.Get(client.roomId); exists {
+					room = current
 				}
-				player.Snake.Speed.X = speed.X
-				player.Snake.Speed.Y = speed.Y
-				room.snakesMap[playerId] = player
+				room.handleDisconnection(client)
 			}
+			return
 		}
-		roomsMutex.Unlock()
-		return
-	}
-
-	err := json.Unmarshal(msg, &message)
-	if err != nil {
-		log.Println("Error parsing message:", err)
-		return
-	}
-
-	switch message.Event {
-	case "newPlayer":
-		if !room.hasGameStarted {
-
-			log.Printf("New player joined: %s", message.Player.Name)
-			message.Player.Type = "player"
-			message.Player.Snake.Speed.X = 1
-			message.Player.Snake.Speed.Y = 0
-			message.Player.Snake.Tail = []Vector{}
-			message.Player.Snake.Size = 0
-
-			roomsMutex.Lock()
-			room.addToWaitingRoom(message.Player)
-			room.broadcastWaitingRoomStatus()
-			room.sendConfig(conn)
-			roomsMutex.Unlock()
-			log.Printf("Config sent to player %s", client.playerId)
-		}
-	case "waitingRoomStatus":
-		log.Printf("Sending waiting room status to room: %s", roomId)
-		room.broadcastWaitingRoomStatus()
-
-	case "startGame":
+		conn.SetReadDeadline(time.Now().Add(heartbeatTimeout()))
 
-		if len(room.waitingRoom) > 0 {
-			log.Printf("Starting game on room: %s", room.id)
-			room.startGame()
+		// Binary frames carry the negotiated codec, text frames are always JSON
+		// or the plain "p" and "m:" commands.
+		if messageType == websocket.BinaryMessage {
+			msg, err = client.codec.toJSON(msg)
+			if err != nil {
+				client.sendError(ErrBadMessage, "Message is not valid "+client.codec.Name, "", "")
+				continue
+			}
 		}
+		processMessage(client, msg)
+	}
+}
 
-	case "updatePlayer":
+// Process incoming messages by handing them to the handler registered for
+// their event.
+func processMessage(client *Client, msg []byte) {
+	ctx := &EventContext{Client: client, Payload: msg}
 
-		snake, exists := room.waitingRoom[message.Player.ID]
-		if !exists {
-			log.Println("Player not found in waiting room")
+	// The plain text commands predate JSON events: "p" is a ping and "m:<key>"
+	// a move. The legacy "m:<playerId>:<key>" form is still accepted but the
+	// embedded ID is ignored: clients only steer their own snake.
+	strMsg := string(msg)
+	switch {
+	case strMsg == "p":
+		ctx.Event = "p"
+	case strings.HasPrefix(strMsg, "m:"):
+		parts := strings.Split(strMsg[2:], ":")
+		ctx.Event = "move"
+		ctx.Payload, _ = json.Marshal(MovePayload{Key: parts[len(parts)-1]})
+	default:
+		var message Message
+		if err := json.Unmarshal(msg, &message); err != nil {
+			client.sendError(ErrBadMessage, "Message is not valid JSON", "", "")
 			return
 		}
-		snake.Colours.Body = message.Player.Colours.Body
-		snake.Colours.Head = message.Player.Colours.Head
-		snake.Colours.Eyes = message.Player.Colours.Eyes
+		ctx.Event = message.Event
+		ctx.RequestID = message.RequestID
+	}
 
-		roomsMutex.Lock()
-		room.waitingRoom[message.Player.ID] = snake
-		room.broadcastWaitingRoomStatus()
-		roomsMutex.Unlock()
+	if room, exists := roomManager.Get(client.roomId); exists {
+		ctx.Room = room
+	}
+	events.Dispatch(ctx)
+}
 
-	default:
-		log.Println("Unknown event received:", message.Event)
+// sendPong answers an extended ping with the server time and the tick of the
+// client's room.
+func sendPong(client *Client, clientTime int64) {
+	pong := &PongMessage{
+		Event:      "pong",
+		ClientTime: clientTime,
+	}
+	if room, exists := roomManager.Get(client.roomId); exists && room.reply(client, pong) {
+		return
 	}
+	pong.ServerTime = time.Now().UnixMilli()
+	client.send(pong)
 }
 
 func webhookHandler(w http.ResponseWriter, r *http.Request) {
@@ -265,8 +316,14 @@ func main() {
 	}
 
 	InitContentful()
+	LoadSettings()
+	InitAuth()
 
 	http.HandleFunc("/ws", handleConnections)
+	http.HandleFunc("/auth/token", tokenHandler)
+	http.HandleFunc("/rooms", roomsHandler)
+	http.HandleFunc("/replays", replaysHandler)
+	http.HandleFunc("/replays/", replayHandler)
 	http.HandleFunc("/webhook", webhookHandler)
 
 	log.Println("WebSocket server started on port", port)
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestMain sets short timeouts for the tests. The server's goroutines read
+// the settings until the process exits, so they are never put back.
+func TestMain(m *testing.M) {
+	replayDir, err := os.MkdirTemp("", "replays")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	GameConfigJSON = Config{FoodStorage: 11, Side: 800, Fps: 50, ScaleFactor: 20}
+	Settings.CountdownDuration = 50 * time.Millisecond
+	Settings.ReconnectGrace = 100 * time.Millisecond
+	Settings.AbandonedRoomTimeout = 200 * time.Millisecond
+	Settings.FinishedRoomTimeout = time.Second
+	Settings.MatchmakingTimeout = 2 * time.Second
+	Settings.ReplayDir = replayDir
+	tokenSecret = []byte("test secret")
+
+	code := m.Run()
+	os.RemoveAll(replayDir)
+	os.Exit(code)
+}
+
+// testServer serves handleConnections and returns the URL to connect to,
+// without the token.
+func testServer(t *testing.T) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", handleConnections)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?token="
+}
+
+// testClient is one websocket connection with its events decoded to JSON.
+type testClient struct {
+	conn   *websocket.Conn
+	codec  *Codec
+	events chan map[string]any
+}
+
+func dialTestClient(url, playerId, query string, codec *Codec) (*testClient, error) {
+	token, err := signToken(Identity{PlayerID: playerId, Name: playerId, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		return nil, err
+	}
+	dialer := websocket.Dialer{Subprotocols: []string{codec.Name}}
+	conn, _, err := dialer.Dial(url+token+query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &testClient{conn: conn, codec: codec, events: make(chan map[string]any, 4096)}
+	go c.read()
+	return c, nil
+}
+
+func (c *testClient) read() {
+	defer close(c.events)
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if data, err = c.codec.toJSON(data); err != nil {
+			continue
+		}
+		var event map[string]any
+		if json.Unmarshal(data, &event) == nil {
+			select {
+			case c.events <- event:
+			default: // Nobody is waiting for snake updates this old
+			}
+		}
+	}
+}
+
+func (c *testClient) send(text string) error {
+	return c.conn.WriteMessage(websocket.TextMessage, []byte(text))
+}
+
+// wait returns the next event of the kind, calling tick every few
+// milliseconds while it waits.
+func (c *testClient) wait(event string, timeout time.Duration, tick func()) (map[string]any, error) {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case received, open := <-c.events:
+			if !open {
+				return nil, fmt.Errorf("connection closed waiting for %s", event)
+			}
+			if received["event"] == event {
+				return received, nil
+			}
+		case <-ticker.C:
+			if tick != nil {
+				tick()
+			}
+		case <-deadline:
+			return nil, fmt.Errorf("timed out waiting for %s", event)
+		}
+	}
+}
+
+// waitForState waits until the room goes to the state.
+func (c *testClient) waitForState(state RoomState) error {
+	for {
+		event, err := c.wait("roomState", 5*time.Second, nil)
+		if err != nil || event["state"] == string(state) {
+			return err
+		}
+	}
+}
+
+// TestManyClients plays duels with many players and spectators at once, to be
+// run with -race.
+func TestManyClients(t *testing.T) {
+	url := testServer(t)
+
+	const players = 24
+	const spectators = 8
+	roomIds := make(chan string, players)
+
+	var wg sync.WaitGroup
+	for i := range players {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := playDuels(url, i, roomIds); err != nil {
+				t.Errorf("player %d: %v", i, err)
+			}
+		}()
+	}
+	for i := range spectators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := spectate(url, i, <-roomIds); err != nil {
+				t.Errorf("spectator %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// playDuels queues for a duel, plays it, plays a rematch and disconnects,
+// half of the players in the middle of the rematch.
+func playDuels(url string, i int, roomIds chan<- string) error {
+	codec := JSONCodec
+	query := "&mode=duel"
+	if i%2 == 0 {
+		codec = MsgpackCodec
+	}
+	if i%3 == 0 {
+		query += "&updates=delta"
+	}
+
+	c, err := dialTestClient(url, fmt.Sprintf("player%d", i), query, codec)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	session, err := c.wait("session", 5*time.Second, nil)
+	if err != nil {
+		return err
+	}
+	roomIds <- session["roomId"].(string)
+
+	// Zigzag into the top left corner, so the walls end the game
+	keys := []string{"u", "l"}
+	moves := 0
+	move := func() {
+		moves++
+		if moves%2 == 0 {
+			c.send("m:" + keys[moves%len(keys)])
+		} else {
+			c.send(`{"event":"move","key":"` + keys[moves%len(keys)] + `"}`)
+		}
+		if moves%5 == 0 {
+			c.send(`{"event":"ping","clientTime":1}`)
+		}
+	}
+
+	for game := range 2 {
+		if game == 0 {
+			c.send(`{"event":"newPlayer","player":{"name":"player"}}`)
+		}
+		c.send(`{"event":"ready"}`)
+		if _, err := c.wait("startGame", 5*time.Second, nil); err != nil {
+			return err
+		}
+		if game == 1 && i%2 == 1 {
+			// Leave in the middle of the game
+			return nil
+		}
+		if _, err := c.wait("gameover", 5*time.Second, move); err != nil {
+			return err
+		}
+		if game == 0 {
+			c.send(`{"event":"rematch"}`)
+			if err := c.waitForState(RoomWaiting); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.send(`{"event":"ping","clientTime":1}`)
+	_, err = c.wait("pong", 5*time.Second, nil)
+	return err
+}
+
+// spectate watches a room for a while.
+func spectate(url string, i int, roomId string) error {
+	c, err := dialTestClient(url, fmt.Sprintf("spectator%d", i), "&spectate="+roomId, JSONCodec)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	if _, err := c.wait("config", 5*time.Second, nil); err != nil {
+		return err
+	}
+	c.send("p")
+	c.send(`{"event":"move","key":"u"}`)
+	time.Sleep(200 * time.Millisecond)
+	return nil
+}
+
+// TestBadBinaryFrame checks that a binary frame the codec cannot read is
+// answered with an error, like invalid JSON.
+func TestBadBinaryFrame(t *testing.T) {
+	url := testServer(t)
+	c, err := dialTestClient(url, "badframe", "&private=true", MsgpackCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.conn.Close()
+
+	if _, err := c.wait("session", 5*time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+	c.conn.WriteMessage(websocket.BinaryMessage, []byte{0x81, 0xa5, 'e'})
+	event, err := c.wait("error", 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event["code"] != string(ErrBadMessage) {
+		t.Errorf("error code %v, want %s", event["code"], ErrBadMessage)
+	}
+}
+
+// TestRoomListRemoved checks that lobby subscribers hear about a public room
+// closing.
+func TestRoomListRemoved(t *testing.T) {
+	url := testServer(t)
+	lobby, err := dialTestClient(url, "lobby", "&private=true", JSONCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lobby.conn.Close()
+	if _, err := lobby.wait("session", 5*time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+	lobby.send(`{"event":"subscribeRooms"}`)
+	if _, err := lobby.wait("rooms", 5*time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	player, err := dialTestClient(url, "leaver", "", JSONCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := player.wait("session", 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	player.conn.Close()
+
+	// The empty room is closed once it has been abandoned
+	for {
+		removed, err := lobby.wait("roomRemoved", 5*time.Second, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed["id"] == session["roomId"] {
+			break
+		}
+	}
+	if _, exists := roomManager.Get(session["roomId"].(string)); exists {
+		t.Error("closed room is still in the room manager")
+	}
+}
+
+// TestOversizedFrame checks that a client sending more than maxMessageSize
+// is disconnected, and that a frame nested too deeply is refused.
+func TestOversizedFrame(t *testing.T) {
+	url := testServer(t)
+	c, err := dialTestClient(url, "bigframe", "&private=true", MsgpackCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.conn.Close()
+	if _, err := c.wait("session", 5*time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deep := append([]byte(strings.Repeat("\x91", maxMsgpackDepth+1)), 0xc0)
+	c.conn.WriteMessage(websocket.BinaryMessage, deep)
+	if _, err := c.wait("error", 5*time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c.conn.WriteMessage(websocket.BinaryMessage, make([]byte, maxMessageSize+1))
+	for {
+		select {
+		case _, open := <-c.events:
+			if !open {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection still open after an oversized frame")
+		}
+	}
+}
+
+// TestPrivateSpectator checks that a private room can only be watched with
+// its join code and password.
+func TestPrivateSpectator(t *testing.T) {
+	url := testServer(t)
+	host, err := dialTestClient(url, "privatehost", "&private=true&password=pw", JSONCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.conn.Close()
+	session, err := host.wait("session", 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roomId := session["roomId"].(string)
+	code := session["joinCode"].(string)
+
+	for _, query := range []string{"", "&code=" + code, "&code=" + code + "&password=nope"} {
+		c, err := dialTestClient(url, "peeker", "&spectate="+roomId+query, JSONCodec)
+		if err == nil {
+			c.conn.Close()
+			t.Errorf("spectated the private room with %q", query)
+		}
+	}
+
+	// Over the event as well, from a public room
+	peeker, err := dialTestClient(url, "peeker", "", JSONCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peeker.conn.Close()
+	public, err := peeker.wait("session", 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := dialTestClient(url, "watcher", "&spectate="+public["roomId"].(string), JSONCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.conn.Close()
+	if _, err := watcher.wait("config", 5*time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+	watcher.send(`{"event":"spectate","id":"` + roomId + `"}`)
+	event, err := watcher.wait("error", 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event["code"] != string(ErrWrongPassword) {
+		t.Errorf("error code %v, want %s", event["code"], ErrWrongPassword)
+	}
+	watcher.send(`{"event":"spectate","id":"` + roomId + `","code":"` + code + `","password":"pw"}`)
+	if _, err := watcher.wait("config", 5*time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	friend, err := dialTestClient(url, "friend", "&spectate="+roomId+"&code="+code+"&password=pw", JSONCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer friend.conn.Close()
+	if _, err := friend.wait("config", 5*time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+}
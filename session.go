@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// Session ties a player to their room across reconnects. A session outlives
+// its connection for Settings.ReconnectGrace while a game is running.
+type Session struct {
+	playerId    string
+	roomId      string
+	resumeToken string
+	client      *Client // nil while the player is disconnected
+	graceTimer  *time.Timer
+}
+
+// Map of playerId to the player's session
+var sessions = make(map[string]*Session)
+var sessionsMutex sync.Mutex
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sessionRoomId returns the room the player's session is in.
+func sessionRoomId(playerId string) (string, bool) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	session, exists := sessions[playerId]
+	if !exists {
+		return "", false
+	}
+	return session.roomId, true
+}
+
+// startSession issues a fresh resume token for a client that just joined a room.
+func startSession(client *Client, room *Room) {
+	session := &Session{
+		playerId:    client.playerId,
+		roomId:      client.roomId,
+		resumeToken: newResumeToken(),
+		client:      client,
+	}
+
+	sessionsMutex.Lock()
+	sessions[client.playerId] = session
+	sessionsMutex.Unlock()
+
+	client.send(SessionMessage{
+		Event:       "session",
+		PlayerID:    session.playerId,
+		RoomID:      session.roomId,
+		ResumeToken: session.resumeToken,
+		Mode:        room.mode.Name,
+		Private:     room.private,
+		JoinCode:    room.joinCode,
+	})
+}
+
+// resumeSession re-binds a new connection to the player's existing session if
+// the resume token matches. A connection that is still open for the same
+// player is handed over instead of being treated as a leave. Returns nil when
+// there is nothing to resume.
+func resumeSession(client *Client, resumeToken string) *Room {
+	if resumeToken == "" {
+		return nil
+	}
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	session, exists := sessions[client.playerId]
+	if !exists || subtle.ConstantTimeCompare([]byte(session.resumeToken), []byte(resumeToken)) != 1 {
+		return nil
+	}
+
+	room, exists := roomManager.Get(session.roomId)
+	if !exists {
+		delete(sessions, client.playerId)
+		return nil
+	}
+
+	old := session.client
+	if session.graceTimer != nil {
+		session.graceTimer.Stop()
+		session.graceTimer = nil
+	}
+
+	session.client = client
+	client.roomId = room.id
+
+	clientsMutex.Lock()
+	clients[client.conn] = client
+	clientsMutex.Unlock()
+
+	client.send(SessionMessage{
+		Event:       "session",
+		PlayerID:    session.playerId,
+		RoomID:      session.roomId,
+		ResumeToken: session.resumeToken,
+		Resumed:     true,
+		Mode:        room.mode.Name,
+		Private:     room.private,
+		JoinCode:    room.joinCode,
+	})
+	if !room.rejoin(client) {
+		delete(sessions, client.playerId)
+		return nil
+	}
+
+	// The old connection leaves after the new one joined, so the player keeps
+	// the host role
+	if old != nil {
+		log.Printf("Handing over session for player %s", client.playerId)
+		clientsMutex.Lock()
+		if clients[old.conn] == old {
+			delete(clients, old.conn)
+		}
+		clientsMutex.Unlock()
+		room.leave(old)
+		old.close()
+	}
+
+	log.Printf("Player %s resumed session in room %s", client.playerId, room.id)
+	return room
+}
+
+// suspendSession keeps a disconnected player's snake frozen in the game for
+// the reconnect grace period. It reports false when there is no running game
+// to hold the player in, in which case the caller removes them straight away.
+// A session that a newer connection has taken over is left alone and reported
+// as handled.
+func suspendSession(client *Client, room *Room) bool {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	session, exists := sessions[client.playerId]
+	if !exists {
+		return false
+	}
+	if session.client != client {
+		return session.client != nil
+	}
+
+	if !room.holdForReconnect(client.playerId) {
+		delete(sessions, client.playerId)
+		return false
+	}
+
+	session.client = nil
+	session.graceTimer = time.AfterFunc(Settings.ReconnectGrace, func() {
+		expireSession(session)
+	})
+
+	log.Printf("Holding snake for player %s for %s", client.playerId, Settings.ReconnectGrace)
+	return true
+}
+
+// expireSession removes the player once the grace period runs out without a
+// reconnect.
+func expireSession(session *Session) {
+	sessionsMutex.Lock()
+	if sessions[session.playerId] != session || session.client != nil {
+		sessionsMutex.Unlock()
+		return
+	}
+	delete(sessions, session.playerId)
+	sessionsMutex.Unlock()
+
+	log.Printf("Reconnect grace expired for player %s", session.playerId)
+
+	room, exists := roomManager.Get(session.roomId)
+	if exists {
+		room.removePlayer(session.playerId)
+	}
+}
+
+// endSession drops the player's session and removes them from its room. Used
+// when a new connection for the same player arrives without a valid resume
+// token.
+func endSession(playerId string) {
+	sessionsMutex.Lock()
+	session, exists := sessions[playerId]
+	if exists {
+		delete(sessions, playerId)
+		if session.graceTimer != nil {
+			session.graceTimer.Stop()
+		}
+	}
+	sessionsMutex.Unlock()
+
+	if !exists {
+		return
+	}
+
+	room, exists := roomManager.Get(session.roomId)
+	if !exists {
+		return
+	}
+
+	if session.client != nil {
+		room.leave(session.client)
+	}
+	room.removePlayer(playerId)
+}
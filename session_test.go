@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+// TestSuspendTakenOverSession checks that an old connection closing after a
+// newer one took over its session leaves the session and snake alone.
+func TestSuspendTakenOverSession(t *testing.T) {
+	old := newTestClient("takeover")
+	room := NewRoomManager().Create(gameModes["party"], old)
+	defer room.do(func() { room.setState(RoomClosed) })
+
+	newer := newTestClient("takeover")
+	session := &Session{playerId: "takeover", roomId: room.id, client: newer}
+	sessionsMutex.Lock()
+	sessions["takeover"] = session
+	sessionsMutex.Unlock()
+	defer endSession("takeover")
+
+	if !suspendSession(old, room) {
+		t.Error("old connection closing was not reported as handled")
+	}
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	if sessions["takeover"] != session || session.client != newer || session.graceTimer != nil {
+		t.Error("old connection closing changed the newer connection's session")
+	}
+}
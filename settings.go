@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ServerSettings holds tuning knobs for the server itself, as opposed to the
+// game config that is loaded from Contentful and sent to clients.
+type ServerSettings struct {
+	PingInterval        time.Duration // How often the server pings each client.
+	MaxMissedHeartbeats int           // Pings a client may miss before eviction.
+	WriteTimeout        time.Duration // Time allowed to write a single frame.
+	ReconnectGrace      time.Duration // How long a dropped player's snake is kept.
+	TokenTTL            time.Duration // Lifetime of issued player tokens.
+	KeyframeInterval    int           // Ticks between full snake_update keyframes.
+	InputQueueDepth     int           // Direction changes buffered per player.
+
+	CountdownDuration    time.Duration // Time between startGame and the first tick.
+	AbandonedRoomTimeout time.Duration // How long a room may wait with nobody in it.
+	FinishedRoomTimeout  time.Duration // How long a finished room is kept around.
+	MatchmakingTimeout   time.Duration // How long a player waits in a queue for a match.
+	LobbyTimeout         time.Duration // Time a waiting room with enough players waits for them to ready up.
+
+	ReplayDir  string // Where finished games are recorded, empty turns recording off.
+	MaxReplays int    // Replays kept on disk, the oldest are removed first.
+}
+
+var Settings = ServerSettings{
+	PingInterval:        10 * time.Second,
+	MaxMissedHeartbeats: 3,
+	WriteTimeout:        5 * time.Second,
+	ReconnectGrace:      15 * time.Second,
+	TokenTTL:            24 * time.Hour,
+	KeyframeInterval:    50,
+	InputQueueDepth:     3,
+
+	CountdownDuration:    3 * time.Second,
+	AbandonedRoomTimeout: time.Minute,
+	FinishedRoomTimeout:  30 * time.Second,
+	MatchmakingTimeout:   30 * time.Second,
+	LobbyTimeout:         time.Minute,
+
+	ReplayDir:  "replays",
+	MaxReplays: 500,
+}
+
+// LoadSettings overrides the default settings with any values found in the
+// environment.
+func LoadSettings() {
+	Settings.PingInterval = envDuration("PING_INTERVAL", Settings.PingInterval)
+	Settings.MaxMissedHeartbeats = envInt("MAX_MISSED_HEARTBEATS", Settings.MaxMissedHeartbeats)
+	Settings.WriteTimeout = envDuration("WRITE_TIMEOUT", Settings.WriteTimeout)
+	Settings.ReconnectGrace = envDuration("RECONNECT_GRACE", Settings.ReconnectGrace)
+	Settings.TokenTTL = envDuration("TOKEN_TTL", Settings.TokenTTL)
+	Settings.KeyframeInterval = envInt("KEYFRAME_INTERVAL", Settings.KeyframeInterval)
+	Settings.InputQueueDepth = envInt("INPUT_QUEUE_DEPTH", Settings.InputQueueDepth)
+	Settings.CountdownDuration = envDuration("COUNTDOWN_DURATION", Settings.CountdownDuration)
+	Settings.AbandonedRoomTimeout = envDuration("ABANDONED_ROOM_TIMEOUT", Settings.AbandonedRoomTimeout)
+	Settings.FinishedRoomTimeout = envDuration("FINISHED_ROOM_TIMEOUT", Settings.FinishedRoomTimeout)
+	Settings.MatchmakingTimeout = envDuration("MATCHMAKING_TIMEOUT", Settings.MatchmakingTimeout)
+	Settings.LobbyTimeout = envDuration("LOBBY_TIMEOUT", Settings.LobbyTimeout)
+	if dir, set := os.LookupEnv("REPLAY_DIR"); set {
+		Settings.ReplayDir = dir
+	}
+	Settings.MaxReplays = envInt("MAX_REPLAYS", Settings.MaxReplays)
+	loadGameModes(os.Getenv("GAME_MODES"))
+}
+
+// heartbeatTimeout is how long a connection may stay silent before it is
+// considered dead.
+func heartbeatTimeout() time.Duration {
+	return Settings.PingInterval * time.Duration(Settings.MaxMissedHeartbeats)
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		log.Printf("Invalid %s %q, using %d", key, value, fallback)
+		return fallback
+	}
+	return number
+}
@@ -0,0 +1,58 @@
+package main
+
+import "log"
+
+// findSpectatedRoom looks up a room to watch. Room IDs are not secret, so a
+// private room also needs its join code and password.
+func findSpectatedRoom(roomId, code, password string) (*Room, error) {
+	room, exists := roomManager.Get(roomId)
+	if !exists {
+		return nil, eventError(ErrRoomNotFound, "Room %s not found", roomId)
+	}
+	if !room.private {
+		return room, nil
+	}
+	if code == "" {
+		return nil, eventError(ErrWrongPassword, "Room %s is private, its join code is required", roomId)
+	}
+	private, err := findPrivateRoom(code, password)
+	if err != nil {
+		return nil, err
+	}
+	if private != room {
+		return nil, eventError(ErrRoomNotFound, "Room %s not found", roomId)
+	}
+	return room, nil
+}
+
+// spectateRoom attaches a spectator to a room, detaching it from the room it
+// was watching before. Returns false if the room has closed.
+func spectateRoom(client *Client, room *Room) bool {
+	if previous, exists := roomManager.Get(client.roomId); exists {
+		previous.unspectate(client)
+	}
+
+	client.roomId = room.id
+	clientsMutex.Lock()
+	clients[client.conn] = client
+	clientsMutex.Unlock()
+
+	if !room.spectate(client) {
+		return false
+	}
+
+	log.Printf("Spectator %s watching room %s", client.playerId, room.id)
+	return true
+}
+
+func stopSpectating(client *Client) {
+	if room, exists := roomManager.Get(client.roomId); exists {
+		room.unspectate(client)
+	}
+
+	clientsMutex.Lock()
+	if clients[client.conn] == client {
+		delete(clients, client.conn)
+	}
+	clientsMutex.Unlock()
+}
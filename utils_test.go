@@ -0,0 +1,30 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRandomDirectionIsSeeded(t *testing.T) {
+	picks := func() []int {
+		rng := rand.New(rand.NewSource(7))
+		var xs []int
+		for range 50 {
+			x, y := getRandomDirection(rng, 1, 0)
+			if x != 0 || (y != 1 && y != -1) {
+				t.Fatalf("turned to (%d, %d) while moving right", x, y)
+			}
+			xs = append(xs, y)
+		}
+		return xs
+	}
+
+	first := picks()
+	for range 20 {
+		for i, y := range picks() {
+			if y != first[i] {
+				t.Fatalf("pick %d differs between runs with the same seed", i)
+			}
+		}
+	}
+}